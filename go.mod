@@ -7,6 +7,7 @@ require (
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
 	google.golang.org/grpc v1.33.2
+	google.golang.org/protobuf v1.25.0
 )
 
 go 1.13
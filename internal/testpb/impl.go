@@ -64,12 +64,26 @@ func (s *testServer) Multiple(stream Foo_MultipleServer) error {
 
 // NewTestClient returns a new TestClient.
 func NewTestClient(l *testing.T) (client FooClient, cleanup func()) {
+	return newTestClient(l, grpc.StatsHandler(&ocgrpc.ServerHandler{}))
+}
+
+// NewAggregatingTestClient returns a new TestClient whose server also
+// installs the ocgrpc interceptors, which end server spans with
+// EndAndAggregate.
+func NewAggregatingTestClient(l *testing.T) (client FooClient, cleanup func()) {
+	return newTestClient(l,
+		grpc.StatsHandler(&ocgrpc.ServerHandler{}),
+		grpc.UnaryInterceptor(ocgrpc.UnaryServerInterceptor),
+		grpc.StreamInterceptor(ocgrpc.StreamServerInterceptor))
+}
+
+func newTestClient(l *testing.T, opts ...grpc.ServerOption) (client FooClient, cleanup func()) {
 	// initialize server
 	listener, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
 		l.Fatal(err)
 	}
-	server := grpc.NewServer(grpc.StatsHandler(&ocgrpc.ServerHandler{}))
+	server := grpc.NewServer(opts...)
 	RegisterFooServer(server, &testServer{})
 	go server.Serve(listener)
 
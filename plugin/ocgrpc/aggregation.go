@@ -0,0 +1,128 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocgrpc
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+const (
+	// aggregationKey is the trailing metadata key carrying aggregated
+	// spans, the gRPC counterpart of the trace.AggregationHeader HTTP
+	// trailer.
+	aggregationKey = "agg-bin"
+
+	// aggregationAcceptKey is the metadata key by which ClientHandler
+	// advertises its trace.AggregationCapabilities, the gRPC counterpart
+	// of the trace.AggregationAcceptHeader.
+	aggregationAcceptKey = "agg-accept"
+)
+
+// UnaryServerInterceptor ends the server span started by ServerHandler with
+// trace.Span.EndAndAggregate once the handler returns, and sends the
+// aggregated spans to the client in the trailing metadata. Spans of calls
+// from clients that do not advertise aggregation capabilities, as
+// ClientHandler does, are exported directly instead. Use it together with
+// ServerHandler:
+//
+//	grpc.NewServer(
+//	    grpc.StatsHandler(&ocgrpc.ServerHandler{}),
+//	    grpc.UnaryInterceptor(ocgrpc.UnaryServerInterceptor))
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if md := endAndAggregate(ctx, err); md != nil {
+		grpc.SetTrailer(ctx, md)
+	}
+	return resp, err
+}
+
+// StreamServerInterceptor is the streaming equivalent of
+// UnaryServerInterceptor.
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	if md := endAndAggregate(ss.Context(), err); md != nil {
+		ss.SetTrailer(md)
+	}
+	return err
+}
+
+// endAndAggregate ends the span in ctx and returns the trailing metadata to
+// send, or nil if no spans were aggregated.
+func endAndAggregate(ctx context.Context, err error) metadata.MD {
+	span := trace.FromContext(ctx)
+	if span == nil {
+		return nil
+	}
+	setSpanStatus(span, err)
+	// EndAndAggregate negotiates with the capabilities of an HTTP request,
+	// so present those advertised in the incoming metadata as one.
+	r := &http.Request{Header: make(http.Header)}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(aggregationAcceptKey); len(v) > 0 {
+			r.Header.Set(trace.AggregationAcceptHeader, v[0])
+		}
+	}
+	w := &trailerWriter{header: make(http.Header)}
+	span.EndAndAggregate(w, r)
+	values := w.header[trace.AggregationHeader]
+	if len(values) == 0 {
+		return nil
+	}
+	return metadata.MD{aggregationKey: values}
+}
+
+// trailerWriter is the http.ResponseWriter handed to
+// trace.Span.EndAndAggregate; only its header is used.
+type trailerWriter struct {
+	header http.Header
+}
+
+func (w *trailerWriter) Header() http.Header         { return w.header }
+func (w *trailerWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *trailerWriter) WriteHeader(int)             {}
+
+// aggregationTrailerKey is the context key of the aggregationTrailer of a
+// client RPC.
+type aggregationTrailerKey struct{}
+
+// aggregationTrailer keeps the aggregated spans received in the trailing
+// metadata of a client RPC until its span is ended.
+type aggregationTrailer struct {
+	mu     sync.Mutex
+	values []string
+}
+
+func (t *aggregationTrailer) set(md metadata.MD) {
+	t.mu.Lock()
+	t.values = md.Get(aggregationKey)
+	t.mu.Unlock()
+}
+
+func (t *aggregationTrailer) header() http.Header {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := make(http.Header)
+	if len(t.values) > 0 {
+		h[trace.AggregationHeader] = t.values
+	}
+	return h
+}
@@ -0,0 +1,69 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocgrpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/Yangfisher1/opencensus-go/internal/testpb"
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+type piggybackExporter struct{}
+
+func (piggybackExporter) ExportSpan(*trace.SpanData) {}
+
+func (piggybackExporter) FilterSpan(*trace.SpanData) trace.ErrorType { return trace.OK }
+
+func (piggybackExporter) AggregateSpanFromHeader(http.Header) {}
+
+func TestAggregatedTrailingMetadata(t *testing.T) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	trace.RegisterExporter(piggybackExporter{})
+	defer trace.UnregisterExporter(piggybackExporter{})
+
+	client, done := testpb.NewAggregatingTestClient(t)
+	defer done()
+
+	ctx, root := trace.StartSpan(context.Background(), "root")
+	if _, err := client.Single(ctx, &testpb.FooRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	root.EndAndAggregate(rec, nil)
+
+	var names []string
+	for _, v := range rec.Header()[trace.AggregationHeader] {
+		sd, err := trace.JSONCodec().Decode(v)
+		if err != nil {
+			t.Fatalf("Decode(%q) failed: %v", v, err)
+		}
+		names = append(names, sd.Name)
+	}
+	sort.Strings(names)
+	want := []string{"root", "testpb.Foo.Single", "testpb.Foo.Single"}
+	if len(names) != len(want) {
+		t.Fatalf("aggregated spans = %v; want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("aggregated spans = %v; want %v", names, want)
+		}
+	}
+}
@@ -22,13 +22,21 @@ import (
 )
 
 // ClientHandler implements a gRPC stats.Handler for recording OpenCensus stats and
-// traces. Use with gRPC clients only.
+// traces. Use with gRPC clients only. It advertises the local
+// trace.AggregationCapabilities to servers, which then send their spans back
+// in the trailing metadata.
 type ClientHandler struct {
 	// StartOptions allows configuring the StartOptions used to create new spans.
 	//
 	// StartOptions.SpanKind will always be set to trace.SpanKindClient
 	// for spans started by this handler.
 	StartOptions trace.StartOptions
+
+	// Whether to treat the span as a user-defined span.
+	IsUserSpan bool
+
+	// Whether to treat the span as an aggregation point.
+	IsAggregationPoint bool
 }
 
 // HandleConn exists to satisfy gRPC stats.Handler.
@@ -39,8 +39,19 @@ func (c *ClientHandler) traceTagRPC(ctx context.Context, rti *stats.RPCTagInfo)
 	ctx, span := trace.StartSpan(ctx, name,
 		trace.WithSampler(c.StartOptions.Sampler),
 		trace.WithSpanKind(trace.SpanKindClient)) // span is ended by traceHandleRPC
+	if c.IsUserSpan {
+		span.AddAttributes(trace.StringAttribute("usr", "y"))
+	}
+	if c.IsAggregationPoint {
+		span.AddAttributes(trace.StringAttribute("agg", "y"))
+	}
+	// The server's aggregated spans arrive in the trailer, before the span
+	// is ended.
+	ctx = context.WithValue(ctx, aggregationTrailerKey{}, &aggregationTrailer{})
 	traceContextBinary := propagation.Binary(span.SpanContext())
-	return metadata.AppendToOutgoingContext(ctx, traceContextKey, string(traceContextBinary))
+	return metadata.AppendToOutgoingContext(ctx,
+		traceContextKey, string(traceContextBinary),
+		aggregationAcceptKey, trace.LocalAggregationCapabilities().String())
 }
 
 // TagRPC creates a new trace span for the server side of the RPC.
@@ -93,15 +104,29 @@ func traceHandleRPC(ctx context.Context, rs stats.RPCStats) {
 		span.AddMessageReceiveEvent(0 /* TODO: messageID */, int64(rs.Length), int64(rs.WireLength))
 	case *stats.OutPayload:
 		span.AddMessageSendEvent(0, int64(rs.Length), int64(rs.WireLength))
+	case *stats.InTrailer:
+		if t, ok := ctx.Value(aggregationTrailerKey{}).(*aggregationTrailer); ok {
+			t.set(rs.Trailer)
+		}
 	case *stats.End:
-		if rs.Error != nil {
-			s, ok := status.FromError(rs.Error)
-			if ok {
-				span.SetStatus(trace.Status{Code: int32(s.Code()), Message: s.Message()})
-			} else {
-				span.SetStatus(trace.Status{Code: int32(codes.Internal), Message: rs.Error.Error()})
-			}
+		setSpanStatus(span, rs.Error)
+		if t, ok := ctx.Value(aggregationTrailerKey{}).(*aggregationTrailer); ok {
+			h := t.header()
+			span.EndAtClient(&h)
+			return
 		}
 		span.End()
 	}
 }
+
+func setSpanStatus(span *trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	s, ok := status.FromError(err)
+	if ok {
+		span.SetStatus(trace.Status{Code: int32(s.Code()), Message: s.Message()})
+	} else {
+		span.SetStatus(trace.Status{Code: int32(codes.Internal), Message: err.Error()})
+	}
+}
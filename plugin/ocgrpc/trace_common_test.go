@@ -16,6 +16,7 @@ package ocgrpc
 
 import (
 	"context"
+	"net/http"
 	"testing"
 
 	"google.golang.org/grpc/metadata"
@@ -44,4 +45,70 @@ func TestClientHandler_traceTagRPC(t *testing.T) {
 	if !ok || len(md) == 0 || len(md[traceContextKey]) == 0 {
 		t.Fatal("no metadata")
 	}
+	if got := md.Get(aggregationAcceptKey); len(got) != 1 || got[0] != trace.LocalAggregationCapabilities().String() {
+		t.Errorf("advertised aggregation capabilities = %q; want the local ones", got)
+	}
+}
+
+type piggybackCollector struct{ spanCollector }
+
+func (*piggybackCollector) FilterSpan(*trace.SpanData) trace.ErrorType { return trace.OK }
+
+func (*piggybackCollector) AggregateSpanFromHeader(http.Header) {}
+
+func TestEndAndAggregateNegotiates(t *testing.T) {
+	for _, advertise := range []bool{false, true} {
+		var c piggybackCollector
+		trace.RegisterExporter(&c)
+		md := metadata.MD{}
+		if advertise {
+			md.Set(aggregationAcceptKey, trace.LocalAggregationCapabilities().String())
+		}
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		ctx, _ = trace.StartSpan(ctx, "server", trace.WithSampler(trace.AlwaysSample()))
+		trailer := endAndAggregate(ctx, nil)
+		trace.UnregisterExporter(&c)
+
+		if advertise && (len(trailer.Get(aggregationKey)) != 1 || len(c.spanCollector) != 0) {
+			t.Errorf("advertising client: trailer %v, %d exported; want the span in the trailer", trailer, len(c.spanCollector))
+		}
+		if !advertise && (trailer != nil || len(c.spanCollector) != 1) {
+			t.Errorf("uninstrumented client: trailer %v, %d exported; want the span exported", trailer, len(c.spanCollector))
+		}
+	}
+}
+
+type spanCollector []*trace.SpanData
+
+func (c *spanCollector) ExportSpan(sd *trace.SpanData) { *c = append(*c, sd) }
+
+func TestClientHandler_traceTagRPCAttributes(t *testing.T) {
+	tests := []struct {
+		ch       *ClientHandler
+		usr, agg bool
+	}{
+		{&ClientHandler{}, false, false},
+		{&ClientHandler{IsUserSpan: true}, true, false},
+		{&ClientHandler{IsAggregationPoint: true}, false, true},
+		{&ClientHandler{IsUserSpan: true, IsAggregationPoint: true}, true, true},
+	}
+	for _, tt := range tests {
+		var c spanCollector
+		trace.RegisterExporter(&c)
+		tt.ch.StartOptions.Sampler = trace.AlwaysSample()
+		ctx := tt.ch.traceTagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "xxx"})
+		trace.FromContext(ctx).End()
+		trace.UnregisterExporter(&c)
+
+		if len(c) != 1 {
+			t.Fatalf("exported %d spans; want 1", len(c))
+		}
+		attrs := c[0].Attributes
+		if got := attrs["usr"] == "y"; got != tt.usr {
+			t.Errorf("IsUserSpan=%v: usr attribute = %v", tt.ch.IsUserSpan, attrs["usr"])
+		}
+		if got := attrs["agg"] == "y"; got != tt.agg {
+			t.Errorf("IsAggregationPoint=%v: agg attribute = %v", tt.ch.IsAggregationPoint, attrs["agg"])
+		}
+	}
 }
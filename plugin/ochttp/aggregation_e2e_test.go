@@ -0,0 +1,233 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ochttp_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/plugin/ochttp"
+	"github.com/Yangfisher1/opencensus-go/trace"
+	"github.com/Yangfisher1/opencensus-go/trace/aggregator"
+	"github.com/Yangfisher1/opencensus-go/trace/policy"
+)
+
+// slowThreshold is the duration from which spans are classified as
+// PerformanceDown and shipped complete.
+const slowThreshold = 30 * time.Millisecond
+
+// hop configures one server of a chain.
+type hop struct {
+	fanOut  int           // calls made to the next hop
+	fail    bool          // respond 500 without calling the next hop
+	latency time.Duration // injected before calling the next hop
+}
+
+// sink is the downstream of the aggregation-point exporter. It tells the
+// spans that reach the aggregation point from those exported immediately.
+type sink struct {
+	mu         sync.Mutex
+	traces     []*aggregator.Trace
+	aggregated []*trace.SpanData
+	immediate  []*trace.SpanData
+}
+
+func (s *sink) ExportSpan(sd *trace.SpanData) {
+	s.mu.Lock()
+	s.immediate = append(s.immediate, sd)
+	s.mu.Unlock()
+}
+
+func (s *sink) ExportTrace(t *aggregator.Trace) {
+	s.mu.Lock()
+	s.traces = append(s.traces, t)
+	s.aggregated = append(s.aggregated, t.Spans()...)
+	s.mu.Unlock()
+}
+
+// chain is a set of chained ochttp.Handler servers called through
+// ochttp.Transport, with every process sharing the same aggregator.Exporter.
+type chain struct {
+	hops    []hop
+	servers []*httptest.Server
+	sink    *sink
+}
+
+func newChain(t *testing.T, hops []hop) *chain {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	c := &chain{hops: hops, sink: &sink{}}
+	exporter := &aggregator.Exporter{
+		Downstream: c.sink,
+		Policy: policy.New(
+			policy.ErrorStatus(),
+			policy.AggregationPoint(),
+			func(sd *trace.SpanData) (trace.ErrorType, bool) {
+				return trace.PerformanceDown, sd.EndTime.Sub(sd.StartTime) >= slowThreshold
+			},
+		),
+		OnError: func(err error) { t.Errorf("aggregation error: %v", err) },
+	}
+	trace.RegisterExporter(exporter)
+	t.Cleanup(func() { trace.UnregisterExporter(exporter) })
+
+	c.servers = make([]*httptest.Server, len(hops))
+	for i := len(hops) - 1; i >= 0; i-- {
+		i, h := i, hops[i]
+		var next string
+		if i+1 < len(hops) {
+			next = c.servers[i+1].URL
+		}
+		client := &http.Client{Transport: &ochttp.Transport{
+			FormatSpanName: func(*http.Request) string { return fmt.Sprintf("call-hop-%d", i+1) },
+		}}
+		c.servers[i] = httptest.NewServer(&ochttp.Handler{
+			FormatSpanName: func(*http.Request) string { return fmt.Sprintf("hop-%d", i) },
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if h.fail {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				time.Sleep(h.latency)
+				for j := 0; j < h.fanOut && next != ""; j++ {
+					req, _ := http.NewRequest("GET", next, nil)
+					resp, err := client.Do(req.WithContext(r.Context()))
+					if err != nil {
+						t.Errorf("hop %d: call %d failed: %v", i, j, err)
+						continue
+					}
+					ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+				}
+				io.WriteString(w, "ok")
+			}),
+		})
+		t.Cleanup(c.servers[i].Close)
+	}
+	return c
+}
+
+// call sends a request to the first hop from a client span marked as the
+// aggregation point.
+func (c *chain) call(t *testing.T) {
+	client := &http.Client{Transport: &ochttp.Transport{
+		FormatSpanName:     func(*http.Request) string { return "root" },
+		IsAggregationPoint: true,
+	}}
+	resp, err := client.Get(c.servers[0].URL)
+	if err != nil {
+		t.Fatalf("root call failed: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+}
+
+// expected returns the names of the spans the chain should aggregate,
+// export immediately and ship complete.
+func (c *chain) expected() (aggregated, immediate, complete []string) {
+	aggregated = []string{"root"}
+	// Spans at and above the deepest slow hop include its latency.
+	slowest := -1
+	for i, h := range c.hops {
+		if h.latency >= slowThreshold {
+			slowest = i
+		}
+	}
+	calls := 1
+	for i, h := range c.hops {
+		names := []string{fmt.Sprintf("hop-%d", i)}
+		if i > 0 {
+			names = append(names, fmt.Sprintf("call-hop-%d", i))
+		}
+		for n := 0; n < calls; n++ {
+			switch {
+			case h.fail:
+				immediate = append(immediate, names...)
+			case i <= slowest:
+				aggregated = append(aggregated, names...)
+				complete = append(complete, names...)
+			default:
+				aggregated = append(aggregated, names...)
+			}
+		}
+		if h.fail {
+			break
+		}
+		calls *= h.fanOut
+	}
+	sort.Strings(aggregated)
+	sort.Strings(immediate)
+	sort.Strings(complete)
+	return aggregated, immediate, complete
+}
+
+func spanNames(spans []*trace.SpanData, filter func(*trace.SpanData) bool) []string {
+	var names []string
+	for _, sd := range spans {
+		if filter == nil || filter(sd) {
+			names = append(names, sd.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMultiHopAggregation(t *testing.T) {
+	tests := []struct {
+		name string
+		hops []hop
+	}{
+		{"linear", []hop{{fanOut: 1}, {fanOut: 1}, {}}},
+		{"fan-out", []hop{{fanOut: 2}, {fanOut: 3}, {}}},
+		{"failure", []hop{{fanOut: 2}, {fanOut: 2, fail: true}, {}}},
+		{"leaf failure", []hop{{fanOut: 1}, {fanOut: 2}, {fail: true}}},
+		{"latency", []hop{{fanOut: 1}, {fanOut: 2, latency: slowThreshold}, {}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChain(t, tt.hops)
+			c.call(t)
+			wantAggregated, wantImmediate, wantComplete := c.expected()
+
+			s := c.sink
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if got := spanNames(s.aggregated, nil); !reflect.DeepEqual(got, wantAggregated) {
+				t.Errorf("aggregated spans = %v; want %v", got, wantAggregated)
+			}
+			if got := spanNames(s.immediate, nil); !reflect.DeepEqual(got, wantImmediate) {
+				t.Errorf("immediately exported spans = %v; want %v", got, wantImmediate)
+			}
+			complete := func(sd *trace.SpanData) bool {
+				_, ok := sd.Attributes[ochttp.PathAttribute]
+				return ok
+			}
+			if got := spanNames(s.aggregated, complete); !reflect.DeepEqual(got, wantComplete) {
+				t.Errorf("complete spans = %v; want %v", got, wantComplete)
+			}
+			if len(s.traces) != 1 || !s.traces[0].Complete() {
+				t.Errorf("got %d traces, first complete %v; want a single complete trace",
+					len(s.traces), len(s.traces) > 0 && s.traces[0].Complete())
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ochttp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/resource"
+	"github.com/Yangfisher1/opencensus-go/resource/resourcekeys"
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+var (
+	// processStart approximates the start of this instance, from which
+	// the init duration of a cold start is measured.
+	processStart = time.Now()
+
+	// invocations counts the requests served by this instance, whether
+	// their spans are aggregated or not.
+	invocations int64
+)
+
+// countInvocation counts a request served by this instance and returns its
+// number, starting at one.
+func countInvocation() int64 {
+	return atomic.AddInt64(&invocations, 1)
+}
+
+// invocationAttrs returns the attributes describing the n-th invocation of
+// the function, recorded on serverless spans.
+func (h *Handler) invocationAttrs(ctx context.Context, n int64) []trace.Attribute {
+	attrs := []trace.Attribute{
+		trace.BoolAttribute(trace.ColdStartAttribute, n == 1),
+		trace.Int64Attribute(trace.InvocationCountAttribute, n),
+	}
+	if n == 1 {
+		initDuration := time.Since(processStart) / time.Microsecond
+		attrs = append(attrs, trace.Int64Attribute(trace.InitDurationAttribute, int64(initDuration)))
+	}
+	h.functionOnce.Do(func() {
+		detect := h.DetectFunction
+		if detect == nil {
+			detect = resource.FaaSFromEnv
+		}
+		if res, err := detect(ctx); err == nil && res != nil {
+			h.functionName = res.Labels[resourcekeys.FaaSKeyName]
+			h.functionVersion = res.Labels[resourcekeys.FaaSKeyVersion]
+		}
+	})
+	if h.functionName != "" {
+		attrs = append(attrs, trace.StringAttribute(trace.FunctionNameAttribute, h.functionName))
+	}
+	if h.functionVersion != "" {
+		attrs = append(attrs, trace.StringAttribute(trace.FunctionVersionAttribute, h.functionVersion))
+	}
+	return attrs
+}
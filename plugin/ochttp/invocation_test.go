@@ -0,0 +1,129 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ochttp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Yangfisher1/opencensus-go/resource"
+	"github.com/Yangfisher1/opencensus-go/resource/resourcekeys"
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+func TestInvocationMetadata(t *testing.T) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	exporter := &testExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	atomic.StoreInt64(&invocations, 0)
+	detected := 0
+	h := &Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		}),
+		DetectFunction: func(context.Context) (*resource.Resource, error) {
+			detected++
+			return &resource.Resource{
+				Type: resourcekeys.FaaSType,
+				Labels: map[string]string{
+					resourcekeys.FaaSKeyName:    "hello",
+					resourcekeys.FaaSKeyVersion: "7",
+				},
+			}, nil
+		},
+	}
+
+	var got []trace.ServerlessSpanData
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(trace.AggregationAcceptHeader, trace.LocalAggregationCapabilities().String())
+		h.ServeHTTP(w, req)
+		values := w.Header()[trace.AggregationHeader]
+		if len(values) != 1 {
+			t.Fatalf("request %d: got %d aggregated spans; want 1", i, len(values))
+		}
+		var ssd trace.ServerlessSpanData
+		if err := json.Unmarshal([]byte(values[0]), &ssd); err != nil {
+			t.Fatalf("cannot decode %q: %v", values[0], err)
+		}
+		got = append(got, ssd)
+	}
+
+	cold, warm := got[0], got[1]
+	if !cold.ColdStart || cold.InitDuration == "" || cold.InvocationCount != "1" {
+		t.Errorf("first invocation = %+v; want a cold start with an init duration", cold)
+	}
+	if warm.ColdStart || warm.InitDuration != "" || warm.InvocationCount != "2" {
+		t.Errorf("second invocation = %+v; want a warm start", warm)
+	}
+	for _, ssd := range got {
+		if ssd.FunctionName != "hello" || ssd.FunctionVersion != "7" {
+			t.Errorf("function = %q, %q; want hello, 7", ssd.FunctionName, ssd.FunctionVersion)
+		}
+	}
+	if detected != 1 {
+		t.Errorf("DetectFunction called %d times; want 1", detected)
+	}
+}
+
+func TestInvocationCountsClassicRequests(t *testing.T) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	exporter := &testExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	atomic.StoreInt64(&invocations, 0)
+	h := &Handler{
+		Mode: ModeAuto,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		}),
+	}
+	// Health checks are not counted.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	// A request from a caller advertising nothing is exported directly,
+	// still recording the invocation.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(exporter.spans) != 1 {
+		t.Fatalf("got %d exported spans; want 1", len(exporter.spans))
+	}
+	if attrs := exporter.spans[0].Attributes; attrs[trace.ColdStartAttribute] != true || attrs[trace.InvocationCountAttribute] != int64(1) {
+		t.Errorf("classic request attributes = %v; want the cold first invocation", attrs)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(trace.AggregationAcceptHeader, trace.LocalAggregationCapabilities().String())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	values := w.Header()[trace.AggregationHeader]
+	if len(values) != 1 {
+		t.Fatalf("got %d aggregated spans; want 1", len(values))
+	}
+	var ssd trace.ServerlessSpanData
+	if err := json.Unmarshal([]byte(values[0]), &ssd); err != nil {
+		t.Fatalf("cannot decode %q: %v", values[0], err)
+	}
+	if ssd.ColdStart || ssd.InitDuration != "" || ssd.InvocationCount != "2" {
+		t.Errorf("aggregated request after a classic one = %+v; want the warm second invocation", ssd)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Yangfisher1/opencensus-go/resource"
 	"github.com/Yangfisher1/opencensus-go/stats"
 	"github.com/Yangfisher1/opencensus-go/tag"
 	"github.com/Yangfisher1/opencensus-go/trace"
@@ -41,7 +42,15 @@ import (
 //	span := trace.FromContext(r.Context())
 //
 // The server span will be automatically ended at the end of ServeHTTP.
+// Depending on Mode, it is either exported directly or aggregated with the
+// spans of the request and sent back to the caller in the
+// trace.AggregationHeader trailer.
 type Handler struct {
+	// Mode selects how the spans of requests are ended. The default,
+	// ModeServerless, ends the spans of every request with
+	// trace.Span.EndAndAggregate.
+	Mode Mode
+
 	// Propagation defines how traces are propagated. If unspecified,
 	// B3 propagation will be used.
 	Propagation propagation.HTTPFormat
@@ -76,76 +85,92 @@ type Handler struct {
 	// addition to the private isHealthEndpoint func which may also indicate
 	// tracing should be skipped.
 	IsHealthEndpoint func(*http.Request) bool
+
+	// AggregationFallback receives the aggregated spans of a response that
+	// cannot carry them in an HTTP trailer: the handler hijacked the
+	// connection or set Content-Length on an HTTP/1.x response, the response
+	// has no body, or the client speaks HTTP/1.0. If nil, such spans are
+	// exported directly with trace.ExportAggregated, and errors are reported
+	// to trace.Config.ErrorHandler.
+	AggregationFallback func(r *http.Request, values []string)
+
+	// DetectFunction detects the function name and version recorded on
+	// serverless spans, from the resourcekeys.FaaSKeyName and
+	// resourcekeys.FaaSKeyVersion labels of the resource. It is called
+	// once, on the first request. If nil, resource.FaaSFromEnv is used.
+	DetectFunction resource.Detector
+
+	functionOnce    sync.Once
+	functionName    string
+	functionVersion string
 }
 
+// Mode selects how a Handler ends the spans of the requests it serves.
+type Mode int
+
+const (
+	// ModeServerless ends spans with trace.Span.EndAndAggregate and always
+	// declares the trace.AggregationHeader trailer. Callers advertising
+	// support for the configured codec in the trace.AggregationAcceptHeader,
+	// as Transport does, get the aggregated spans in the trailer within
+	// their size limit; for other callers the spans are exported directly
+	// and the trailer stays empty.
+	ModeServerless Mode = iota
+	// ModeClassic ends spans with span.End, exporting them directly, and
+	// declares no trailer.
+	ModeClassic
+	// ModeAuto behaves like ModeServerless for requests whose caller
+	// advertises support for the configured codec in the
+	// trace.AggregationAcceptHeader, as Transport does, and like
+	// ModeClassic otherwise. Unlike ModeServerless, it declares no trailer
+	// to callers that cannot use it.
+	ModeAuto
+)
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var tags addedTags
-	// Adding the trailer headers here
-	w.Header().Set("Trailer", "Agg")
+	aggregate := h.aggregates(r)
+	if aggregate {
+		// Declare the trailer before the handler can write the header.
+		w.Header().Set("Trailer", trace.AggregationHeader)
+	}
 
-	r, traceEnd := h.startServerlessTrace(w, r)
-	defer traceEnd(w, r)
-	w, statsEnd := h.startStats(w, r)
+	r, traceEnd := h.startTrace(w, r, aggregate)
+	track, statsEnd := h.startStats(w, r)
+	defer traceEnd(track)
 	defer statsEnd(&tags)
 	handler := h.Handler
 	if handler == nil {
 		handler = http.DefaultServeMux
 	}
 	r = r.WithContext(context.WithValue(r.Context(), addedTagsKey{}, &tags))
-	handler.ServeHTTP(w, r)
+	handler.ServeHTTP(track.wrappedResponseWriter(), r)
 }
 
-func (h *Handler) startTrace(w http.ResponseWriter, r *http.Request) (*http.Request, func()) {
-	if h.IsHealthEndpoint != nil && h.IsHealthEndpoint(r) || isHealthEndpoint(r.URL.Path) {
-		return r, func() {}
-	}
-	var name string
-	if h.FormatSpanName == nil {
-		name = spanNameFromURL(r)
-	} else {
-		name = h.FormatSpanName(r)
-	}
-	ctx := r.Context()
-
-	startOpts := h.StartOptions
-	if h.GetStartOptions != nil {
-		startOpts = h.GetStartOptions(r)
-	}
-
-	var span *trace.Span
-	sc, ok := h.extractSpanContext(r)
-	if ok && !h.IsPublicEndpoint {
-		ctx, span = trace.StartSpanWithRemoteParent(ctx, name, sc,
-			trace.WithSampler(startOpts.Sampler),
-			trace.WithSpanKind(trace.SpanKindServer))
-	} else {
-		ctx, span = trace.StartSpan(ctx, name,
-			trace.WithSampler(startOpts.Sampler),
-			trace.WithSpanKind(trace.SpanKindServer),
-		)
-		if ok {
-			span.AddLink(trace.Link{
-				TraceID:    sc.TraceID,
-				SpanID:     sc.SpanID,
-				Type:       trace.LinkTypeParent,
-				Attributes: nil,
-			})
-		}
-	}
-	span.AddAttributes(requestAttrs(r)...)
-	if r.Body == nil {
-		// TODO: Handle cases where ContentLength is not set.
-	} else if r.ContentLength > 0 {
-		span.AddMessageReceiveEvent(0, /* TODO: messageID */
-			r.ContentLength, -1)
+// aggregates reports whether the span of r is ended with
+// trace.Span.EndAndAggregate, according to Mode.
+func (h *Handler) aggregates(r *http.Request) bool {
+	switch h.Mode {
+	case ModeClassic:
+		return false
+	case ModeAuto:
+		return trace.AcceptsAggregation(r)
+	default:
+		// EndAndAggregate exports the spans directly if the caller did
+		// not advertise support for them.
+		return true
 	}
-	return r.WithContext(ctx), span.End
 }
 
-func (h *Handler) startServerlessTrace(w http.ResponseWriter, r *http.Request) (*http.Request, func(http.ResponseWriter, *http.Request)) {
+// startTrace starts the server span of r. The returned function ends it
+// with span.End, or with trace.Span.EndAndAggregate if aggregate is set.
+// Unless Mode is ModeClassic, the span also records the invocation of the
+// function, which is counted for every request but health checks.
+func (h *Handler) startTrace(w http.ResponseWriter, r *http.Request, aggregate bool) (*http.Request, func(*trackingResponseWriter)) {
 	if h.IsHealthEndpoint != nil && h.IsHealthEndpoint(r) || isHealthEndpoint(r.URL.Path) {
-		return r, func(http.ResponseWriter, *http.Request) {}
+		return r, func(*trackingResponseWriter) {}
 	}
+	invocation := countInvocation()
 	var name string
 	if h.FormatSpanName == nil {
 		name = spanNameFromURL(r)
@@ -180,13 +205,20 @@ func (h *Handler) startServerlessTrace(w http.ResponseWriter, r *http.Request) (
 		}
 	}
 	span.AddAttributes(requestAttrs(r)...)
+	if h.Mode != ModeClassic {
+		span.AddAttributes(h.invocationAttrs(ctx, invocation)...)
+	}
 	if r.Body == nil {
 		// TODO: Handle cases where ContentLength is not set.
 	} else if r.ContentLength > 0 {
 		span.AddMessageReceiveEvent(0, /* TODO: messageID */
 			r.ContentLength, -1)
 	}
-	return r.WithContext(ctx), span.EndAndAggregate
+	r = r.WithContext(ctx)
+	if !aggregate {
+		return r, func(*trackingResponseWriter) { span.End() }
+	}
+	return r, func(t *trackingResponseWriter) { h.endAndAggregate(span, t, r) }
 }
 
 func (h *Handler) extractSpanContext(r *http.Request) (trace.SpanContext, bool) {
@@ -196,7 +228,7 @@ func (h *Handler) extractSpanContext(r *http.Request) (trace.SpanContext, bool)
 	return h.Propagation.SpanContextFromRequest(r)
 }
 
-func (h *Handler) startStats(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func(tags *addedTags)) {
+func (h *Handler) startStats(w http.ResponseWriter, r *http.Request) (*trackingResponseWriter, func(tags *addedTags)) {
 	ctx, _ := tag.New(r.Context(),
 		tag.Upsert(Host, r.Host),
 		tag.Upsert(Path, r.URL.Path),
@@ -213,7 +245,7 @@ func (h *Handler) startStats(w http.ResponseWriter, r *http.Request) (http.Respo
 		track.reqSize = r.ContentLength
 	}
 	stats.Record(ctx, ServerRequestCount.M(1))
-	return track.wrappedResponseWriter(), track.end
+	return track, track.end
 }
 
 type trackingResponseWriter struct {
@@ -225,6 +257,13 @@ type trackingResponseWriter struct {
 	statusLine string
 	endOnce    sync.Once
 	writer     http.ResponseWriter
+
+	// The state of the response header when it was written, deciding how
+	// aggregated spans can be delivered; see aggregationDelivery.
+	headerWritten   bool
+	trailerDeclared bool
+	fixedLength     bool
+	hijacked        bool
 }
 
 // Compile time assertion for ResponseWriter interface
@@ -259,6 +298,7 @@ func (t *trackingResponseWriter) Header() http.Header {
 }
 
 func (t *trackingResponseWriter) Write(data []byte) (int, error) {
+	t.writeHeaderState()
 	n, err := t.writer.Write(data)
 	t.respSize += int64(n)
 	// Add message event for request bytes sent.
@@ -268,6 +308,9 @@ func (t *trackingResponseWriter) Write(data []byte) (int, error) {
 }
 
 func (t *trackingResponseWriter) WriteHeader(statusCode int) {
+	if statusCode >= 200 {
+		t.writeHeaderState()
+	}
 	t.writer.WriteHeader(statusCode)
 	t.statusCode = statusCode
 	t.statusLine = http.StatusText(t.statusCode)
@@ -281,11 +324,17 @@ func (t *trackingResponseWriter) WriteHeader(statusCode int) {
 // This implementation is based on https://github.com/felixge/httpsnoop.
 func (t *trackingResponseWriter) wrappedResponseWriter() http.ResponseWriter {
 	var (
-		hj, i0 = t.writer.(http.Hijacker)
+		_, i0  = t.writer.(http.Hijacker)
 		cn, i1 = t.writer.(http.CloseNotifier)
 		pu, i2 = t.writer.(http.Pusher)
-		fl, i3 = t.writer.(http.Flusher)
-		rf, i4 = t.writer.(io.ReaderFrom)
+		_, i3  = t.writer.(http.Flusher)
+		_, i4  = t.writer.(io.ReaderFrom)
+
+		// Hijacking, flushing and ReadFrom go through t, which records
+		// the state of the response header.
+		hj http.Hijacker = t
+		fl http.Flusher  = t
+		rf io.ReaderFrom = t
 	)
 
 	switch {
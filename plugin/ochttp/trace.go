@@ -68,19 +68,21 @@ func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		req = req.WithContext(ctx)
 	}
 
+	// SpanContextToRequest and the aggregation header modify the Request,
+	// which is contrary to the contract for http.RoundTripper, so we need
+	// to pass it a copy of the Request.
+	// However, the Request struct itself was already copied by
+	// the WithContext calls above and so we just need to copy the header.
+	header := make(http.Header)
+	for k, v := range req.Header {
+		header[k] = v
+	}
+	req.Header = header
 	if t.format != nil {
-		// SpanContextToRequest will modify its Request argument, which is
-		// contrary to the contract for http.RoundTripper, so we need to
-		// pass it a copy of the Request.
-		// However, the Request struct itself was already copied by
-		// the WithContext calls above and so we just need to copy the header.
-		header := make(http.Header)
-		for k, v := range req.Header {
-			header[k] = v
-		}
-		req.Header = header
 		t.format.SpanContextToRequest(span.SpanContext(), req)
 	}
+	// The client span consumes the aggregated spans of the response.
+	req.Header.Set(trace.AggregationAcceptHeader, trace.LocalAggregationCapabilities().String())
 
 	span.AddAttributes(requestAttrs(req)...)
 
@@ -101,8 +103,12 @@ func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		return resp, err
 	}
 
-	// Prevent overloading of trailer header
-	resp.Trailer = make(http.Header)
+	// Keep the trailer the server announced: once the body has been read it
+	// holds the aggregated spans of the downstream call, which the client
+	// span merges with its own and forwards to the enclosing span.
+	if resp.Trailer == nil {
+		resp.Trailer = make(http.Header)
+	}
 
 	span.AddAttributes(responseAttrs(resp)...)
 	span.SetStatus(TraceStatus(resp.StatusCode, resp.Status))
@@ -110,7 +116,6 @@ func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// span.End() will be invoked after
 	// a read from resp.Body returns io.EOF or when
 	// resp.Body.Close() is invoked.
-	// FIXME: The problem could be here by doubling the aggregated span info into the trailer within the resp Body.
 	bt := &bodyTracker{rc: resp.Body, span: span, trailer: &resp.Trailer}
 	resp.Body = wrappedBody(bt, resp.Body)
 	return resp, err
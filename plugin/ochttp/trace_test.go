@@ -0,0 +1,240 @@
+// Copyright 2018, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ochttp
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+type testExporter struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (t *testExporter) ExportSpan(s *trace.SpanData) {
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+}
+
+func (t *testExporter) FilterSpan(s *trace.SpanData) trace.ErrorType {
+	return trace.OK
+}
+
+func (t *testExporter) AggregateSpanFromHeader(w http.Header) {}
+
+func TestAggregatedTrailerPropagation(t *testing.T) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	exporter := &testExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	backend := httptest.NewServer(&Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "backend")
+		}),
+		FormatSpanName: func(*http.Request) string { return "backend" },
+	})
+	defer backend.Close()
+
+	client := &http.Client{Transport: &Transport{
+		FormatSpanName: func(*http.Request) string { return "call-backend" },
+	}}
+	frontend := httptest.NewServer(&Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, _ := http.NewRequest("GET", backend.URL, nil)
+			resp, err := client.Do(req.WithContext(r.Context()))
+			if err != nil {
+				t.Errorf("backend request failed: %v", err)
+				return
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			io.WriteString(w, "frontend")
+		}),
+		FormatSpanName: func(*http.Request) string { return "frontend" },
+	})
+	defer frontend.Close()
+
+	resp, err := getAdvertising(http.DefaultClient, frontend.URL)
+	if err != nil {
+		t.Fatalf("frontend request failed: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var names []string
+	for _, v := range resp.Trailer[trace.AggregationHeader] {
+		var ssd trace.ServerlessSpanData
+		if err := json.Unmarshal([]byte(v), &ssd); err != nil {
+			t.Fatalf("cannot decode %q: %v", v, err)
+		}
+		names = append(names, ssd.Name)
+	}
+	sort.Strings(names)
+	want := []string{"backend", "call-backend", "frontend"}
+	if len(names) != len(want) {
+		t.Fatalf("aggregated spans = %v; want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("aggregated spans = %v; want %v", names, want)
+			break
+		}
+	}
+	if len(exporter.spans) != 0 {
+		t.Errorf("got %d immediately exported spans; want 0", len(exporter.spans))
+	}
+}
+
+func TestClassicFrontendExportsAggregated(t *testing.T) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	exporter := &testExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	backend := httptest.NewServer(&Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "backend")
+		}),
+		FormatSpanName: func(*http.Request) string { return "backend" },
+	})
+	defer backend.Close()
+	client := &http.Client{Transport: &Transport{
+		FormatSpanName: func(*http.Request) string { return "call-backend" },
+	}}
+	frontend := httptest.NewServer(&Handler{
+		Mode: ModeClassic,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, _ := http.NewRequest("GET", backend.URL, nil)
+			resp, err := client.Do(req.WithContext(r.Context()))
+			if err != nil {
+				t.Errorf("backend request failed: %v", err)
+				return
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			io.WriteString(w, "frontend")
+		}),
+		FormatSpanName: func(*http.Request) string { return "frontend" },
+	})
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL)
+	if err != nil {
+		t.Fatalf("frontend request failed: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The frontend span ends after the response was written.
+	exported := func() []string {
+		exporter.mu.Lock()
+		defer exporter.mu.Unlock()
+		var names []string
+		for _, sd := range exporter.spans {
+			names = append(names, sd.Name)
+		}
+		return names
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(exported()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	names := exported()
+	sort.Strings(names)
+	if want := []string{"backend", "call-backend", "frontend"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("exported spans = %v; want %v", names, want)
+	}
+}
+
+func TestHandlerMode(t *testing.T) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	tests := []struct {
+		mode      Mode
+		advertise bool
+		declare   bool // whether the trailer is declared
+		aggregate bool // whether the span is in the trailer, or exported
+	}{
+		{ModeServerless, false, true, false},
+		{ModeServerless, true, true, true},
+		{ModeClassic, false, false, false},
+		{ModeClassic, true, false, false},
+		{ModeAuto, false, false, false},
+		{ModeAuto, true, true, true},
+	}
+	for _, tt := range tests {
+		exporter := &testExporter{}
+		trace.RegisterExporter(exporter)
+		h := &Handler{
+			Mode: tt.mode,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "ok")
+			}),
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.advertise {
+			req.Header.Set(trace.AggregationAcceptHeader, trace.LocalAggregationCapabilities().String())
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		trace.UnregisterExporter(exporter)
+
+		declared := w.Header().Get("Trailer") == trace.AggregationHeader
+		aggregated := len(w.Header()[trace.AggregationHeader])
+		if declared != tt.declare {
+			t.Errorf("mode %d, advertised %v: trailer declared %v; want %v", tt.mode, tt.advertise, declared, tt.declare)
+		}
+		switch {
+		case tt.aggregate && (aggregated != 1 || len(exporter.spans) != 0):
+			t.Errorf("mode %d, advertised %v: %d spans in trailer, %d exported; want 1 in trailer", tt.mode, tt.advertise, aggregated, len(exporter.spans))
+		case !tt.aggregate && (aggregated != 0 || len(exporter.spans) != 1):
+			t.Errorf("mode %d, advertised %v: %d spans in trailer, %d exported; want 1 exported", tt.mode, tt.advertise, aggregated, len(exporter.spans))
+		}
+	}
+}
+
+func TestTransportAdvertisesAggregation(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(trace.AggregationAcceptHeader)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	caps, err := trace.ParseAggregationCapabilities(got)
+	if err != nil {
+		t.Fatalf("invalid %s header %q: %v", trace.AggregationAcceptHeader, got, err)
+	}
+	if !caps.Accepts(trace.JSONCodec()) {
+		t.Errorf("advertised capabilities %q do not accept the default codec", got)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ochttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+// aggregationDelivery is how the aggregated spans of a response reach the
+// caller.
+type aggregationDelivery int
+
+const (
+	// deliverDeclaredTrailer sets the trailer declared in the Trailer
+	// header by ServeHTTP.
+	deliverDeclaredTrailer aggregationDelivery = iota
+	// deliverPrefixedTrailer sets an undeclared trailer with
+	// http.TrailerPrefix, used when the handler replaced the Trailer
+	// header before the response header was written.
+	deliverPrefixedTrailer
+	// deliverFallback hands the spans to Handler.AggregationFallback, as
+	// the response cannot carry trailers.
+	deliverFallback
+)
+
+// endAndAggregate ends span with trace.Span.EndAndAggregate and delivers the
+// aggregated spans in the response trailer if possible, or through the
+// fallback otherwise.
+func (h *Handler) endAndAggregate(span *trace.Span, t *trackingResponseWriter, r *http.Request) {
+	rec := &headerRecorder{header: make(http.Header)}
+	span.EndAndAggregate(rec, r)
+	values := rec.header[trace.AggregationHeader]
+	if len(values) == 0 {
+		return
+	}
+	switch t.aggregationDelivery(r) {
+	case deliverDeclaredTrailer:
+		t.writer.Header()[trace.AggregationHeader] = values
+	case deliverPrefixedTrailer:
+		t.writer.Header()[http.TrailerPrefix+trace.AggregationHeader] = values
+	default:
+		if h.AggregationFallback != nil {
+			h.AggregationFallback(r, values)
+		} else if err := trace.ExportAggregated(values); err != nil {
+			trace.ReportError(err)
+		}
+	}
+}
+
+// aggregationDelivery decides how the aggregated spans of the response to r
+// are delivered, from the state of the header when it was written.
+func (t *trackingResponseWriter) aggregationDelivery(r *http.Request) aggregationDelivery {
+	t.writeHeaderState()
+	status := t.statusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	switch {
+	case t.hijacked:
+		return deliverFallback
+	case r.Method == http.MethodHead || !bodyAllowedForStatus(status):
+		return deliverFallback
+	case r.ProtoMajor < 2 && (r.ProtoMinor == 0 || t.fixedLength):
+		// Trailers need chunked encoding in HTTP/1.x.
+		return deliverFallback
+	case t.trailerDeclared:
+		return deliverDeclaredTrailer
+	default:
+		return deliverPrefixedTrailer
+	}
+}
+
+// writeHeaderState records the state of the response header the first time
+// it is written, as later changes other than trailers are ignored.
+func (t *trackingResponseWriter) writeHeaderState() {
+	if t.headerWritten {
+		return
+	}
+	t.headerWritten = true
+	h := t.writer.Header()
+	t.fixedLength = h.Get("Content-Length") != ""
+	for _, v := range h["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			if http.CanonicalHeaderKey(strings.TrimSpace(k)) == trace.AggregationHeader {
+				t.trailerDeclared = true
+			}
+		}
+	}
+}
+
+func (t *trackingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	t.hijacked = true
+	return t.writer.(http.Hijacker).Hijack()
+}
+
+func (t *trackingResponseWriter) Flush() {
+	t.writeHeaderState()
+	t.writer.(http.Flusher).Flush()
+}
+
+func (t *trackingResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	t.writeHeaderState()
+	return t.writer.(io.ReaderFrom).ReadFrom(src)
+}
+
+// bodyAllowedForStatus reports whether a response with the given status may
+// have a body, and so trailers.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// headerRecorder is the http.ResponseWriter handed to
+// trace.Span.EndAndAggregate; only its header is used.
+type headerRecorder struct {
+	header http.Header
+}
+
+func (r *headerRecorder) Header() http.Header         { return r.header }
+func (r *headerRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (r *headerRecorder) WriteHeader(int)             {}
@@ -0,0 +1,232 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ochttp
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+var trailerHandlers = map[string]http.HandlerFunc{
+	"plain": func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	},
+	"flushing": func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "o")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "k")
+		w.(http.Flusher).Flush()
+	},
+	"content-length": func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "2")
+		io.WriteString(w, "ok")
+	},
+	"replaced trailer": func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Other")
+		io.WriteString(w, "ok")
+		w.(http.Flusher).Flush()
+	},
+	"no content": func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	},
+	"hijacked": func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+		conn.Close()
+	},
+}
+
+// getAdvertising GETs url advertising the local aggregation capabilities,
+// as Transport does.
+func getAdvertising(client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(trace.AggregationAcceptHeader, trace.LocalAggregationCapabilities().String())
+	return client.Do(req)
+}
+
+type fallbackRecorder struct {
+	values chan []string
+}
+
+func (f *fallbackRecorder) record(r *http.Request, values []string) {
+	f.values <- values
+}
+
+// wait returns the values passed to the fallback, which can be called after
+// the client received a hijacked response.
+func (f *fallbackRecorder) wait(timeout time.Duration) []string {
+	select {
+	case v := <-f.values:
+		return v
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func testTrailerDelivery(t *testing.T, proto string, newServer func(http.Handler) *httptest.Server, client *http.Client, want map[string]bool) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	exporter := &testExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	for name, inTrailer := range want {
+		fallback := &fallbackRecorder{values: make(chan []string, 1)}
+		srv := newServer(&Handler{
+			Handler:             trailerHandlers[name],
+			AggregationFallback: fallback.record,
+		})
+		resp, err := getAdvertising(client, srv.URL)
+		if err != nil {
+			t.Errorf("%s %s: request failed: %v", proto, name, err)
+			srv.Close()
+			continue
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Close()
+
+		if inTrailer {
+			if got := len(resp.Trailer[trace.AggregationHeader]); got != 1 {
+				t.Errorf("%s %s: got %d aggregated spans in trailer; want 1", proto, name, got)
+			}
+			if got := fallback.wait(0); got != nil {
+				t.Errorf("%s %s: fallback called for a response with trailers", proto, name)
+			}
+		} else if got := fallback.wait(time.Second); len(got) != 1 {
+			t.Errorf("%s %s: fallback got %d aggregated spans; want 1", proto, name, len(got))
+		}
+	}
+}
+
+func TestAggregationTrailerHTTP1(t *testing.T) {
+	testTrailerDelivery(t, "HTTP/1.1", httptest.NewServer, http.DefaultClient, map[string]bool{
+		"plain":            true,
+		"flushing":         true,
+		"content-length":   false,
+		"replaced trailer": true,
+		"no content":       false,
+		"hijacked":         false,
+	})
+}
+
+func TestAggregationTrailerH2C(t *testing.T) {
+	newServer := func(h http.Handler) *httptest.Server {
+		return httptest.NewServer(h2c.NewHandler(h, &http2.Server{}))
+	}
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	testTrailerDelivery(t, "h2c", newServer, client, map[string]bool{
+		"plain":            true,
+		"flushing":         true,
+		"content-length":   true,
+		"replaced trailer": true,
+		"no content":       false,
+	})
+}
+
+func TestAggregationFallbackExportsByDefault(t *testing.T) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	exporter := &testExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	srv := httptest.NewServer(&Handler{Handler: trailerHandlers["content-length"]})
+	defer srv.Close()
+	resp, err := getAdvertising(http.DefaultClient, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	if len(exporter.spans) != 1 {
+		t.Errorf("got %d exported spans; want 1", len(exporter.spans))
+	}
+}
+
+// undecodableCodec is trace.JSONCodec with a failing Decode.
+type undecodableCodec struct{ trace.PiggybackCodec }
+
+func (undecodableCodec) Decode(string) (*trace.SpanData, error) {
+	return nil, errors.New("undecodable")
+}
+
+func TestAggregationFallbackReportsErrors(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	trace.ApplyConfig(trace.Config{
+		DefaultSampler: trace.AlwaysSample(),
+		PiggybackCodec: undecodableCodec{trace.JSONCodec()},
+		ErrorHandler: func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		},
+	})
+	defer trace.ApplyConfig(trace.Config{PiggybackCodec: trace.JSONCodec(), ErrorHandler: func(error) {}})
+	exporter := &testExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	srv := httptest.NewServer(&Handler{Handler: trailerHandlers["content-length"]})
+	defer srv.Close()
+	resp, err := getAdvertising(http.DefaultClient, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The span ends after the response was written.
+	reported := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs)
+	}
+	deadline := time.Now().Add(time.Second)
+	for reported() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := reported(); n != 1 {
+		t.Errorf("got %d errors reported; want 1", n)
+	}
+}
@@ -24,6 +24,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/Yangfisher1/opencensus-go/resource/resourcekeys"
 )
 
 // Environment variables used by FromEnv to decode a resource.
@@ -108,6 +110,41 @@ func FromEnv(context.Context) (*Resource, error) {
 
 var _ Detector = FromEnv
 
+// faasEnvVars lists, per platform, the environment variables holding the
+// name and the version of a function.
+var faasEnvVars = []struct{ name, version string }{
+	{"AWS_LAMBDA_FUNCTION_NAME", "AWS_LAMBDA_FUNCTION_VERSION"}, // AWS Lambda
+	{"K_SERVICE", "K_REVISION"},                                 // Knative, Cloud Run, Cloud Functions
+	{"FUNCTION_NAME", "X_GOOGLE_FUNCTION_VERSION"},              // Legacy Cloud Functions runtimes
+	{"WEBSITE_SITE_NAME", ""},                                   // Azure Functions
+}
+
+// FaaSFromEnv is a detector that loads the name and version of a serverless
+// function from the environment variables set by common FaaS platforms. It
+// returns a resource of type resourcekeys.FaaSType, or nil if none of the
+// variables are set.
+func FaaSFromEnv(context.Context) (*Resource, error) {
+	for _, vars := range faasEnvVars {
+		name := strings.TrimSpace(os.Getenv(vars.name))
+		if name == "" {
+			continue
+		}
+		res := &Resource{
+			Type:   resourcekeys.FaaSType,
+			Labels: map[string]string{resourcekeys.FaaSKeyName: name},
+		}
+		if vars.version != "" {
+			if version := strings.TrimSpace(os.Getenv(vars.version)); version != "" {
+				res.Labels[resourcekeys.FaaSKeyVersion] = version
+			}
+		}
+		return res, nil
+	}
+	return nil, nil
+}
+
+var _ Detector = FaaSFromEnv
+
 // merge resource information from b into a. In case of a collision, a takes precedence.
 func merge(a, b *Resource) *Resource {
 	if a == nil {
@@ -18,8 +18,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"reflect"
 	"testing"
+
+	"github.com/Yangfisher1/opencensus-go/resource/resourcekeys"
 )
 
 func TestMerge(t *testing.T) {
@@ -161,3 +164,35 @@ func TestMultiDetector(t *testing.T) {
 		t.Fatalf("unexpected error: want %v, got %v", wantErr, err)
 	}
 }
+
+func TestFaaSFromEnv(t *testing.T) {
+	for _, vars := range faasEnvVars {
+		for _, v := range []string{vars.name, vars.version} {
+			if v != "" {
+				os.Unsetenv(v)
+			}
+		}
+	}
+	if res, err := FaaSFromEnv(context.Background()); err != nil || res != nil {
+		t.Fatalf("FaaSFromEnv() without variables = (%v, %v); want (nil, nil)", res, err)
+	}
+
+	os.Setenv("K_SERVICE", "hello")
+	os.Setenv("K_REVISION", "hello-00003")
+	defer os.Unsetenv("K_SERVICE")
+	defer os.Unsetenv("K_REVISION")
+	got, err := FaaSFromEnv(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := &Resource{
+		Type: resourcekeys.FaaSType,
+		Labels: map[string]string{
+			resourcekeys.FaaSKeyName:    "hello",
+			resourcekeys.FaaSKeyVersion: "hello-00003",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected resource: want %v, got %v", want, got)
+	}
+}
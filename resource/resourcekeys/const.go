@@ -66,3 +66,12 @@ const (
 	HostKeyID       = "host.id"
 	HostKeyType     = "host.type"
 )
+
+// Constants for FaaS (function as a service) resources.
+const (
+	FaaSType = "faas"
+
+	// The name and version of the function an instance serves.
+	FaaSKeyName    = "faas.name"
+	FaaSKeyVersion = "faas.version"
+)
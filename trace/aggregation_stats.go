@@ -0,0 +1,154 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/stats"
+	"github.com/Yangfisher1/opencensus-go/tag"
+)
+
+// The following measures record the overhead of span aggregation. They are
+// tagged with KeySpanName; views of them are provided by package
+// aggregationstats.
+var (
+	AggregationHeaderBytes = stats.Int64(
+		"opencensus.io/trace/aggregation/header_bytes",
+		"Bytes of AggregationHeader values added to a response",
+		stats.UnitBytes)
+	AggregationSpansPiggybacked = stats.Int64(
+		"opencensus.io/trace/aggregation/spans_piggybacked",
+		"Number of finished spans encoded into the AggregationHeader",
+		stats.UnitDimensionless)
+	AggregationSpansExported = stats.Int64(
+		"opencensus.io/trace/aggregation/spans_exported",
+		"Number of finished spans exported immediately instead of piggybacked",
+		stats.UnitDimensionless)
+	AggregationEncodeLatency = stats.Float64(
+		"opencensus.io/trace/aggregation/encode_latency",
+		"Time spent encoding a span for the AggregationHeader",
+		stats.UnitMilliseconds)
+	AggregationDecodeLatency = stats.Float64(
+		"opencensus.io/trace/aggregation/decode_latency",
+		"Time spent decoding an AggregationHeader value",
+		stats.UnitMilliseconds)
+	AggregationDroppedSpans = stats.Int64(
+		"opencensus.io/trace/aggregation/dropped_spans",
+		"Number of piggybacked spans dropped to fit the AggregationHeader budget",
+		stats.UnitDimensionless)
+)
+
+// ExporterFailures counts the spans an exporter failed to handle, such as
+// spans that could not be encoded for it. It is tagged with KeyExporter.
+var ExporterFailures = stats.Int64(
+	"opencensus.io/trace/exporter_failures",
+	"Number of spans an exporter failed to handle",
+	stats.UnitDimensionless)
+
+// The following tags are applied to the aggregation measures.
+var (
+	// KeySpanName is the name of the span the measurement is about. For
+	// AggregationHeaderBytes and AggregationDroppedSpans it is the span
+	// ending with the response.
+	KeySpanName = tag.MustNewKey("trace_span_name")
+
+	// KeyErrorType is the ErrorType a span was exported immediately for.
+	// It is only applied to AggregationSpansExported.
+	KeyErrorType = tag.MustNewKey("trace_error_type")
+
+	// KeyExporter is the Go type of the exporter, as in
+	// "*aggregator.Exporter". It is only applied to ExporterFailures.
+	KeyExporter = tag.MustNewKey("trace_exporter")
+)
+
+func recordAggregationStats(name string, ms ...stats.Measurement) {
+	_ = stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(KeySpanName, name)}, ms...)
+}
+
+// recordImmediateExport records a span exported immediately for typ.
+func recordImmediateExport(name string, typ ErrorType) {
+	_ = stats.RecordWithTags(context.Background(),
+		[]tag.Mutator{tag.Upsert(KeySpanName, name), tag.Upsert(KeyErrorType, typ.String())},
+		AggregationSpansExported.M(1))
+}
+
+// recordExporterFailure records a span e failed to handle.
+func recordExporterFailure(e Exporter) {
+	_ = stats.RecordWithTags(context.Background(),
+		[]tag.Mutator{tag.Upsert(KeyExporter, fmt.Sprintf("%T", e))},
+		ExporterFailures.M(1))
+}
+
+// piggyback encodes sd for the AggregationHeader, complete if full and
+// compact with the schema of ae otherwise, and records it.
+func piggyback(codec PiggybackCodec, sd *SpanData, ae AggregatingExporter, full bool) (string, error) {
+	start := time.Now()
+	var (
+		v   string
+		err error
+	)
+	if full {
+		v, err = codec.EncodeSpanData(sd)
+	} else {
+		v, err = codec.EncodeCompact(sd, compactSchemaOf(ae))
+	}
+	if err != nil {
+		return "", err
+	}
+	recordAggregationStats(sd.Name,
+		AggregationEncodeLatency.M(sinceInMilliseconds(start)),
+		AggregationSpansPiggybacked.M(1))
+	return v, nil
+}
+
+// DecodeAggregated decodes an AggregationHeader value with codec and records
+// the time it took in AggregationDecodeLatency.
+func DecodeAggregated(codec PiggybackCodec, value string) (*SpanData, error) {
+	start := time.Now()
+	sd, err := codec.Decode(value)
+	if err != nil {
+		return nil, err
+	}
+	recordAggregationStats(sd.Name, AggregationDecodeLatency.M(sinceInMilliseconds(start)))
+	return sd, nil
+}
+
+// recordHeaderStats records the size of the AggregationHeader values of a
+// response and the spans dropped from it, given the values before and after
+// fitting the budget.
+func recordHeaderStats(name string, before, after []string) {
+	ms := []stats.Measurement{AggregationHeaderBytes.M(int64(aggregationSize(after)))}
+	if n := droppedCount(after) - droppedCount(before); n > 0 {
+		ms = append(ms, AggregationDroppedSpans.M(int64(n)))
+	}
+	recordAggregationStats(name, ms...)
+}
+
+func droppedCount(values []string) int {
+	n := 0
+	for _, v := range values {
+		if d, ok := ParseDroppedEntry(v); ok {
+			n += d
+		}
+	}
+	return n
+}
+
+func sinceInMilliseconds(start time.Time) float64 {
+	return float64(time.Since(start)) / float64(time.Millisecond)
+}
@@ -0,0 +1,102 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aggregationstats provides views of the span aggregation measures
+// recorded by package trace, to be registered in every process of a call
+// chain. The measures themselves are defined in package trace, which cannot
+// depend on package view.
+package aggregationstats // import "github.com/Yangfisher1/opencensus-go/trace/aggregationstats"
+
+import (
+	"github.com/Yangfisher1/opencensus-go/stats/view"
+	"github.com/Yangfisher1/opencensus-go/tag"
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+// Default distributions used by the aggregation views.
+var (
+	DefaultSizeDistribution    = view.Distribution(64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536)
+	DefaultLatencyDistribution = view.Distribution(0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1, 2, 5, 10)
+)
+
+// Package aggregationstats provides the following views. You still need to
+// register these views for data to actually be collected.
+var (
+	HeaderBytesView = &view.View{
+		Name:        "opencensus.io/trace/aggregation/header_bytes",
+		Description: "Size distribution of the AggregationHeader values added to responses, by span name",
+		TagKeys:     []tag.Key{trace.KeySpanName},
+		Measure:     trace.AggregationHeaderBytes,
+		Aggregation: DefaultSizeDistribution,
+	}
+
+	SpansPiggybackedView = &view.View{
+		Name:        "opencensus.io/trace/aggregation/spans_piggybacked",
+		Description: "Count of spans piggybacked, by span name",
+		TagKeys:     []tag.Key{trace.KeySpanName},
+		Measure:     trace.AggregationSpansPiggybacked,
+		Aggregation: view.Sum(),
+	}
+
+	SpansExportedView = &view.View{
+		Name:        "opencensus.io/trace/aggregation/spans_exported",
+		Description: "Count of spans exported immediately, by span name and error type",
+		TagKeys:     []tag.Key{trace.KeySpanName, trace.KeyErrorType},
+		Measure:     trace.AggregationSpansExported,
+		Aggregation: view.Sum(),
+	}
+
+	EncodeLatencyView = &view.View{
+		Name:        "opencensus.io/trace/aggregation/encode_latency",
+		Description: "Latency distribution of encoding spans for the AggregationHeader, by span name",
+		TagKeys:     []tag.Key{trace.KeySpanName},
+		Measure:     trace.AggregationEncodeLatency,
+		Aggregation: DefaultLatencyDistribution,
+	}
+
+	DecodeLatencyView = &view.View{
+		Name:        "opencensus.io/trace/aggregation/decode_latency",
+		Description: "Latency distribution of decoding AggregationHeader values, by span name",
+		TagKeys:     []tag.Key{trace.KeySpanName},
+		Measure:     trace.AggregationDecodeLatency,
+		Aggregation: DefaultLatencyDistribution,
+	}
+
+	ExporterFailuresView = &view.View{
+		Name:        "opencensus.io/trace/exporter_failures",
+		Description: "Count of spans exporters failed to handle, by exporter",
+		TagKeys:     []tag.Key{trace.KeyExporter},
+		Measure:     trace.ExporterFailures,
+		Aggregation: view.Sum(),
+	}
+
+	DroppedSpansView = &view.View{
+		Name:        "opencensus.io/trace/aggregation/dropped_spans",
+		Description: "Count of piggybacked spans dropped to fit the AggregationHeader budget, by span name",
+		TagKeys:     []tag.Key{trace.KeySpanName},
+		Measure:     trace.AggregationDroppedSpans,
+		Aggregation: view.Sum(),
+	}
+)
+
+// DefaultViews are the aggregation views provided by this package.
+var DefaultViews = []*view.View{
+	HeaderBytesView,
+	SpansPiggybackedView,
+	SpansExportedView,
+	EncodeLatencyView,
+	DecodeLatencyView,
+	DroppedSpansView,
+	ExporterFailuresView,
+}
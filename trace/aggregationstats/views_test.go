@@ -0,0 +1,96 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregationstats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Yangfisher1/opencensus-go/stats/view"
+	"github.com/Yangfisher1/opencensus-go/tag"
+	"github.com/Yangfisher1/opencensus-go/trace"
+	"github.com/Yangfisher1/opencensus-go/trace/aggregator"
+	"github.com/Yangfisher1/opencensus-go/trace/policy"
+)
+
+type discardExporter struct{}
+
+func (discardExporter) ExportSpan(*trace.SpanData) {}
+
+func TestDefaultViews(t *testing.T) {
+	if err := view.Register(DefaultViews...); err != nil {
+		t.Fatalf("Register() error: %v", err)
+	}
+	defer view.Unregister(DefaultViews...)
+
+	e := &aggregator.Exporter{
+		Downstream: discardExporter{},
+		Policy:     policy.New(policy.ErrorStatus()),
+	}
+	trace.RegisterExporter(e)
+	defer trace.UnregisterExporter(e)
+
+	ctx, server := trace.StartSpan(context.Background(), "views-server", trace.WithSampler(trace.AlwaysSample()))
+	_, ok := trace.StartSpan(ctx, "views-ok")
+	ok.EndAtClient(&http.Header{})
+	_, failed := trace.StartSpan(ctx, "views-failed")
+	failed.SetStatus(trace.Status{Code: trace.StatusCodeInternal})
+	failed.EndAtClient(&http.Header{})
+	server.EndAndAggregate(httptest.NewRecorder(), nil)
+
+	sum := func(v *view.View, tags ...tag.Tag) float64 {
+		rows, err := view.RetrieveData(v.Name)
+		if err != nil {
+			t.Fatalf("RetrieveData(%q) error: %v", v.Name, err)
+		}
+	rows:
+		for _, row := range rows {
+			for _, want := range tags {
+				found := false
+				for _, got := range row.Tags {
+					found = found || got == want
+				}
+				if !found {
+					continue rows
+				}
+			}
+			switch d := row.Data.(type) {
+			case *view.SumData:
+				return d.Value
+			case *view.DistributionData:
+				return float64(d.Count)
+			}
+		}
+		return 0
+	}
+	name := func(n string) tag.Tag { return tag.Tag{Key: trace.KeySpanName, Value: n} }
+	if got := sum(SpansPiggybackedView, name("views-ok")); got != 1 {
+		t.Errorf("spans piggybacked for views-ok = %v; want 1", got)
+	}
+	if got := sum(SpansPiggybackedView, name("views-server")); got != 1 {
+		t.Errorf("spans piggybacked for views-server = %v; want 1", got)
+	}
+	if got := sum(SpansExportedView, name("views-failed"), tag.Tag{Key: trace.KeyErrorType, Value: "Error"}); got != 1 {
+		t.Errorf("spans exported for views-failed = %v; want 1", got)
+	}
+	if got := sum(EncodeLatencyView, name("views-ok")); got != 1 {
+		t.Errorf("encode latency count for views-ok = %v; want 1", got)
+	}
+	if got := sum(HeaderBytesView, name("views-server")); got != 1 {
+		t.Errorf("header bytes count for views-server = %v; want 1", got)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aggregator reconstructs traces from the spans piggybacked in
+// trace.AggregationHeader values at an aggregation point.
+package aggregator // import "github.com/Yangfisher1/opencensus-go/trace/aggregator"
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+	"github.com/Yangfisher1/opencensus-go/trace/policy"
+)
+
+// Node is a span together with its children in a reconstructed trace.
+type Node struct {
+	Span     *trace.SpanData
+	Children []*Node
+
+	// Skew is the offset added to the timestamps of Span by
+	// Trace.CorrectClockSkew.
+	Skew time.Duration
+}
+
+// Trace is a tree of spans sharing a trace ID.
+type Trace struct {
+	TraceID trace.TraceID
+
+	// Roots holds the spans whose parent is not part of the trace. For a
+	// complete trace it has exactly one element.
+	Roots []*Node
+
+	// Dropped is the number of spans reported as dropped on the way to the
+	// aggregation point to fit the header budget.
+	Dropped int
+
+	// Exported holds the spans of the trace that were exported immediately
+	// instead of being piggybacked, as recorded by TailSampler. They are
+	// not part of Roots.
+	Exported []*trace.SpanData
+}
+
+// Complete reports whether the trace has a single root and no spans were
+// dropped.
+func (t *Trace) Complete() bool {
+	return len(t.Roots) == 1 && t.Dropped == 0
+}
+
+// Spans returns the spans of the trace, parents before their children.
+func (t *Trace) Spans() []*trace.SpanData {
+	var spans []*trace.SpanData
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		spans = append(spans, n.Span)
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	for _, r := range t.Roots {
+		walk(r)
+	}
+	return spans
+}
+
+// Decode parses the AggregationHeader values in h. Spans that occur more
+// than once are reported once, preferring complete over compact encodings.
+// dropped is the number of spans reported as dropped by the sender. Values
+// that cannot be decoded are skipped and the first error is returned along
+// with the spans that could be decoded.
+func Decode(h http.Header, codec trace.PiggybackCodec) (spans []*trace.SpanData, dropped int, err error) {
+	seen := make(map[trace.SpanID]int)
+	for _, v := range h[trace.AggregationHeader] {
+		if n, ok := trace.ParseDroppedEntry(v); ok {
+			dropped += n
+			continue
+		}
+		sd, derr := trace.DecodeAggregated(codec, v)
+		if derr != nil {
+			if err == nil {
+				err = derr
+			}
+			continue
+		}
+		// Compact spans carry no trace options, but were sampled to be
+		// piggybacked in the first place.
+		complete := sd.TraceOptions.IsSampled()
+		sd.TraceOptions |= 1
+		if i, ok := seen[sd.SpanID]; ok {
+			if complete {
+				spans[i] = sd
+			}
+			continue
+		}
+		seen[sd.SpanID] = len(spans)
+		spans = append(spans, sd)
+	}
+	return spans, dropped, err
+}
+
+// BuildTraces groups spans by trace ID and links them into trees by
+// ParentSpanID. Siblings are ordered by start time.
+func BuildTraces(spans []*trace.SpanData) []*Trace {
+	var traces []*Trace
+	byTrace := make(map[trace.TraceID][]*Node)
+	for _, sd := range spans {
+		if _, ok := byTrace[sd.TraceID]; !ok {
+			traces = append(traces, &Trace{TraceID: sd.TraceID})
+		}
+		byTrace[sd.TraceID] = append(byTrace[sd.TraceID], &Node{Span: sd})
+	}
+	for _, t := range traces {
+		nodes := byTrace[t.TraceID]
+		bySpan := make(map[trace.SpanID]*Node, len(nodes))
+		for _, n := range nodes {
+			bySpan[n.Span.SpanID] = n
+		}
+		for _, n := range nodes {
+			if p, ok := bySpan[n.Span.ParentSpanID]; ok && p != n {
+				p.Children = append(p.Children, n)
+			} else {
+				t.Roots = append(t.Roots, n)
+			}
+		}
+		sortNodes(t.Roots)
+		for _, n := range nodes {
+			sortNodes(n.Children)
+		}
+	}
+	return traces
+}
+
+func sortNodes(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Span.StartTime.Before(nodes[j].Span.StartTime)
+	})
+}
+
+// TraceExporter may be implemented by the downstream exporter of an
+// Exporter to receive each reconstructed trace as a whole.
+type TraceExporter interface {
+	ExportTrace(t *Trace)
+}
+
+// Exporter is a trace exporter to register at an aggregation point. It
+// decodes the spans piggybacked by downstream calls, rebuilds their traces
+// and passes them to Downstream.
+type Exporter struct {
+	// Downstream receives the reconstructed spans, parents before children.
+	// If it implements TraceExporter, it receives whole traces instead.
+	Downstream trace.Exporter
+
+	// Codec decodes the header values. It must match
+	// trace.Config.PiggybackCodec. Defaults to trace.JSONCodec.
+	Codec trace.PiggybackCodec
+
+	// Policy classifies finished spans. If it has no classifiers, spans
+	// marked as aggregation points with the "agg" attribute are aggregated
+	// and all others are piggybacked.
+	Policy policy.Policy
+
+	// Schema selects the optional fields of the compact spans piggybacked
+	// for this exporter, such as their status or "http.status_code".
+	Schema trace.CompactSchema
+
+	// OnError is called with header values that could not be decoded.
+	OnError func(error)
+}
+
+var _ trace.CompactSchemaExporter = (*Exporter)(nil)
+
+var defaultPolicy = policy.New(policy.AggregationPoint())
+
+// ExportSpan passes spans exported immediately straight to Downstream.
+func (e *Exporter) ExportSpan(sd *trace.SpanData) {
+	e.Downstream.ExportSpan(sd)
+}
+
+// FilterSpan classifies sd using Policy.
+func (e *Exporter) FilterSpan(sd *trace.SpanData) trace.ErrorType {
+	if len(e.Policy.Classifiers) == 0 {
+		return defaultPolicy.FilterSpan(sd)
+	}
+	return e.Policy.FilterSpan(sd)
+}
+
+// CompactSchema returns Schema.
+func (e *Exporter) CompactSchema() trace.CompactSchema {
+	return e.Schema
+}
+
+// AggregateSpanFromHeader rebuilds the traces carried in h, corrects their
+// clock skew and exports them.
+func (e *Exporter) AggregateSpanFromHeader(h http.Header) {
+	codec := e.Codec
+	if codec == nil {
+		codec = trace.JSONCodec()
+	}
+	spans, dropped, err := Decode(h, codec)
+	if err != nil && e.OnError != nil {
+		e.OnError(err)
+	}
+	for _, t := range BuildTraces(spans) {
+		t.Dropped = dropped
+		t.CorrectClockSkew()
+		if te, ok := e.Downstream.(TraceExporter); ok {
+			te.ExportTrace(t)
+			continue
+		}
+		for _, sd := range t.Spans() {
+			e.Downstream.ExportSpan(sd)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+type recordingExporter struct {
+	spans []*trace.SpanData
+}
+
+func (r *recordingExporter) ExportSpan(sd *trace.SpanData) {
+	r.spans = append(r.spans, sd)
+}
+
+func testSpan(id, parent byte, name string, offset time.Duration) *trace.SpanData {
+	start := time.UnixMicro(1600000000000000).Add(offset)
+	sd := &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID: trace.TraceID{1},
+			SpanID:  trace.SpanID{id},
+		},
+		Name:      name,
+		StartTime: start,
+		EndTime:   start.Add(time.Millisecond),
+	}
+	if parent != 0 {
+		sd.ParentSpanID = trace.SpanID{parent}
+	}
+	return sd
+}
+
+func TestAggregateSpanFromHeader(t *testing.T) {
+	for name, codec := range map[string]trace.PiggybackCodec{
+		"json":   trace.JSONCodec(),
+		"binary": trace.BinaryCodec(),
+		"proto":  trace.ProtoCodec(),
+	} {
+		h := make(http.Header)
+		add := func(v string, err error) {
+			if err != nil {
+				t.Fatalf("%s: encoding failed: %v", name, err)
+			}
+			h.Add(trace.AggregationHeader, v)
+		}
+		// Children are piggybacked before their parents.
+		add(codec.EncodeServerless(testSpan(3, 2, "grandchild", 2*time.Millisecond)))
+		slow := testSpan(4, 1, "slow", 3*time.Millisecond)
+		slow.TraceOptions = 1
+		slow.Status = trace.Status{Code: trace.StatusCodeDeadlineExceeded}
+		add(codec.EncodeSpanData(slow))
+		add(codec.EncodeServerless(testSpan(2, 1, "child", time.Millisecond)))
+		add(codec.EncodeServerless(testSpan(1, 0, "root", 0)))
+		// A duplicate of the complete span in its compact form.
+		add(codec.EncodeServerless(slow))
+
+		rec := &recordingExporter{}
+		e := &Exporter{Downstream: rec, Codec: codec}
+		e.AggregateSpanFromHeader(h)
+
+		var got []string
+		for _, sd := range rec.spans {
+			got = append(got, sd.Name)
+		}
+		want := []string{"root", "child", "grandchild", "slow"}
+		if len(got) != len(want) {
+			t.Fatalf("%s: exported %v; want %v", name, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s: exported %v; want %v", name, got, want)
+			}
+		}
+		if code := rec.spans[3].Status.Code; code != trace.StatusCodeDeadlineExceeded {
+			t.Errorf("%s: complete span status = %d; want %d", name, code, trace.StatusCodeDeadlineExceeded)
+		}
+	}
+}
+
+func TestBuildTraces(t *testing.T) {
+	spans := []*trace.SpanData{
+		testSpan(2, 1, "child", time.Millisecond),
+		testSpan(3, 9, "orphan", 0),
+		testSpan(1, 0, "root", 0),
+	}
+	traces := BuildTraces(spans)
+	if len(traces) != 1 {
+		t.Fatalf("got %d traces; want 1", len(traces))
+	}
+	tr := traces[0]
+	if len(tr.Roots) != 2 || tr.Complete() {
+		t.Fatalf("got %d roots, complete = %v; want 2 roots, incomplete", len(tr.Roots), tr.Complete())
+	}
+	for _, r := range tr.Roots {
+		if r.Span.Name == "root" && (len(r.Children) != 1 || r.Children[0].Span.Name != "child") {
+			t.Errorf("root children = %v; want [child]", r.Children)
+		}
+	}
+}
+
+func TestDecodeDropped(t *testing.T) {
+	h := make(http.Header)
+	h.Add(trace.AggregationHeader, "dropped=3")
+	h.Add(trace.AggregationHeader, "not a span")
+	spans, dropped, err := Decode(h, trace.JSONCodec())
+	if err == nil {
+		t.Errorf("Decode() of invalid value succeeded")
+	}
+	if len(spans) != 0 || dropped != 3 {
+		t.Errorf("Decode() = %d spans, %d dropped; want 0, 3", len(spans), dropped)
+	}
+}
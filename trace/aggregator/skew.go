@@ -0,0 +1,68 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+// CorrectClockSkew shifts the timestamps of the spans of t recorded on other
+// machines so that the trace is causally consistent.
+//
+// Each span with a remote parent, such as a server span, starts a hop to
+// another process whose clock may be skewed. If the span does not fit in
+// the time between its parent sending the request and receiving the
+// response, its process is assumed to be off by the difference, with the
+// network latency split evenly between the request and the response. The
+// span and its descendants in the same process are shifted by that offset,
+// which is recorded in the Skew of their nodes. Spans that fit in their
+// parent are left unchanged, as the skew cannot be told from the latency.
+func (t *Trace) CorrectClockSkew() {
+	for _, r := range t.Roots {
+		correctClockSkew(r, 0)
+	}
+}
+
+func correctClockSkew(n *Node, skew time.Duration) {
+	if skew != 0 {
+		n.Span.StartTime = n.Span.StartTime.Add(skew)
+		n.Span.EndTime = n.Span.EndTime.Add(skew)
+		n.Skew = skew
+	}
+	for _, c := range n.Children {
+		if c.Span.HasRemoteParent {
+			correctClockSkew(c, hopSkew(n.Span, c.Span))
+		} else {
+			correctClockSkew(c, skew)
+		}
+	}
+	sortNodes(n.Children)
+}
+
+// hopSkew estimates the clock offset of the process of the server span
+// from the process of its client span, which is already corrected.
+func hopSkew(client, server *trace.SpanData) time.Duration {
+	if !server.StartTime.Before(client.StartTime) && !server.EndTime.After(client.EndTime) {
+		return 0
+	}
+	latency := (client.EndTime.Sub(client.StartTime) - server.EndTime.Sub(server.StartTime)) / 2
+	if latency < 0 {
+		// The server clock runs faster; align the start times.
+		latency = 0
+	}
+	return client.StartTime.Add(latency).Sub(server.StartTime)
+}
@@ -0,0 +1,105 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+// hopSpan returns a span started at offset from the start of testSpan, on
+// a clock off by skew, that lasts d.
+func hopSpan(id, parent byte, name string, offset, skew, d time.Duration, remote bool) *trace.SpanData {
+	sd := testSpan(id, parent, name, offset+skew)
+	sd.EndTime = sd.StartTime.Add(d)
+	sd.HasRemoteParent = remote
+	return sd
+}
+
+func TestCorrectClockSkew(t *testing.T) {
+	const ms = time.Millisecond
+	spans := []*trace.SpanData{
+		hopSpan(1, 0, "client", 0, 0, 10*ms, false),
+		// The server process runs an hour ahead.
+		hopSpan(2, 1, "server", 2*ms, time.Hour, 6*ms, true),
+		hopSpan(3, 2, "server-client", 3*ms, time.Hour, 4*ms, false),
+		// The next process runs a second behind.
+		hopSpan(4, 3, "leaf", 4*ms, -time.Second, 2*ms, true),
+		// A server span that fits in its client is left alone.
+		hopSpan(5, 1, "in-time", 1*ms, 0, 1*ms, true),
+	}
+	tr := BuildTraces(spans)[0]
+	tr.CorrectClockSkew()
+
+	start := testSpan(0, 0, "", 0).StartTime
+	want := map[string]struct {
+		start time.Duration
+		skew  time.Duration
+	}{
+		"client":        {0, 0},
+		"server":        {2 * ms, -time.Hour},
+		"server-client": {3 * ms, -time.Hour},
+		"leaf":          {4 * ms, time.Second},
+		"in-time":       {1 * ms, 0},
+	}
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		w := want[n.Span.Name]
+		if got := n.Span.StartTime.Sub(start); got != w.start {
+			t.Errorf("%s: corrected start = %v; want %v", n.Span.Name, got, w.start)
+		}
+		if n.Skew != w.skew {
+			t.Errorf("%s: Skew = %v; want %v", n.Span.Name, n.Skew, w.skew)
+		}
+		for _, c := range n.Children {
+			if c.Span.StartTime.Before(n.Span.StartTime) || c.Span.EndTime.After(n.Span.EndTime) {
+				t.Errorf("%s does not fit in its parent %s", c.Span.Name, n.Span.Name)
+			}
+			walk(c)
+		}
+	}
+	walk(tr.Roots[0])
+	if got := tr.Roots[0].Children[0].Span.Name; got != "in-time" {
+		t.Errorf("first child of the root = %q; want in-time after correction", got)
+	}
+}
+
+func TestExporterCorrectsClockSkew(t *testing.T) {
+	codec := trace.JSONCodec()
+	h := make(http.Header)
+	for _, sd := range []*trace.SpanData{
+		hopSpan(2, 1, "server", time.Millisecond, time.Minute, time.Millisecond, true),
+		hopSpan(1, 0, "client", 0, 0, 3*time.Millisecond, false),
+	} {
+		v, err := codec.EncodeServerless(sd)
+		if err != nil {
+			t.Fatalf("EncodeServerless() error: %v", err)
+		}
+		h.Add(trace.AggregationHeader, v)
+	}
+	rec := &recordingExporter{}
+	(&Exporter{Downstream: rec, Codec: codec}).AggregateSpanFromHeader(h)
+	if len(rec.spans) != 2 {
+		t.Fatalf("exported %d spans; want 2", len(rec.spans))
+	}
+	client, server := rec.spans[0], rec.spans[1]
+	if server.StartTime.Before(client.StartTime) || server.EndTime.After(client.EndTime) {
+		t.Errorf("server span [%v, %v] not within client span [%v, %v]",
+			server.StartTime, server.EndTime, client.StartTime, client.EndTime)
+	}
+}
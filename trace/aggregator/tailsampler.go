@@ -0,0 +1,211 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+// Rule decides whether a reconstructed trace is worth exporting.
+type Rule func(t *Trace) bool
+
+// DefaultMaxPendingTraces is the default of TailSampler.MaxPendingTraces.
+const DefaultMaxPendingTraces = 1024
+
+// TailSampler is the downstream of an Exporter that makes the sampling
+// decision once a whole trace has reached the aggregation point. A trace is
+// exported if any of its rules keeps it; spans exported immediately are
+// always passed on.
+//
+// Spans exported immediately, such as failed spans under policy.Default,
+// never reach the aggregation point in a header. TailSampler remembers them
+// by trace ID and hands them to the rules in Trace.Exported.
+type TailSampler struct {
+	// Downstream receives the spans of the kept traces.
+	Downstream trace.Exporter
+
+	// Rules are consulted in order until one keeps the trace.
+	Rules []Rule
+
+	// MaxPendingTraces is the number of traces whose immediately exported
+	// spans are remembered until the trace is evaluated. The oldest trace
+	// is forgotten when it is exceeded. Defaults to
+	// DefaultMaxPendingTraces.
+	MaxPendingTraces int
+
+	mu      sync.Mutex
+	pending map[trace.TraceID][]*trace.SpanData
+	order   []trace.TraceID
+}
+
+var (
+	_ trace.Exporter = (*TailSampler)(nil)
+	_ TraceExporter  = (*TailSampler)(nil)
+)
+
+// ExportSpan passes spans exported immediately straight to Downstream and
+// remembers them for the evaluation of their trace.
+func (s *TailSampler) ExportSpan(sd *trace.SpanData) {
+	s.remember(sd)
+	s.Downstream.ExportSpan(sd)
+}
+
+// ExportTrace exports the spans of t if any rule keeps it. The spans of t
+// exported immediately are added to t.Exported first.
+func (s *TailSampler) ExportTrace(t *Trace) {
+	t.Exported = append(t.Exported, s.take(t.TraceID)...)
+	for _, r := range s.Rules {
+		if r(t) {
+			if te, ok := s.Downstream.(TraceExporter); ok {
+				te.ExportTrace(t)
+				return
+			}
+			for _, sd := range t.Spans() {
+				s.Downstream.ExportSpan(sd)
+			}
+			return
+		}
+	}
+}
+
+func (s *TailSampler) remember(sd *trace.SpanData) {
+	max := s.MaxPendingTraces
+	if max <= 0 {
+		max = DefaultMaxPendingTraces
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		s.pending = make(map[trace.TraceID][]*trace.SpanData)
+	}
+	if _, ok := s.pending[sd.TraceID]; !ok {
+		for len(s.order) >= max {
+			delete(s.pending, s.order[0])
+			s.order = s.order[1:]
+		}
+		s.order = append(s.order, sd.TraceID)
+	}
+	s.pending[sd.TraceID] = append(s.pending[sd.TraceID], sd)
+}
+
+// take returns and forgets the spans of the trace id exported immediately.
+func (s *TailSampler) take(id trace.TraceID) []*trace.SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spans, ok := s.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(s.pending, id)
+	for i, o := range s.order {
+		if o == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return spans
+}
+
+// allSpans returns the spans of t, including those exported immediately.
+func allSpans(t *Trace) []*trace.SpanData {
+	return append(t.Spans(), t.Exported...)
+}
+
+// AnyError keeps traces containing a span with a non-OK status, including
+// spans exported immediately.
+func AnyError() Rule {
+	return func(t *Trace) bool {
+		for _, sd := range allSpans(t) {
+			if sd.Status.Code != trace.StatusCodeOK {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MinDuration keeps traces spanning at least d, from the earliest start to
+// the latest end of their spans, including spans exported immediately.
+func MinDuration(d time.Duration) Rule {
+	return func(t *Trace) bool {
+		return traceDuration(t) >= d
+	}
+}
+
+func traceDuration(t *Trace) time.Duration {
+	var start, end time.Time
+	for i, sd := range allSpans(t) {
+		if i == 0 || sd.StartTime.Before(start) {
+			start = sd.StartTime
+		}
+		if i == 0 || sd.EndTime.After(end) {
+			end = sd.EndTime
+		}
+	}
+	return end.Sub(start)
+}
+
+// RateLimit keeps up to perSecond traces per second for each root span
+// name, with bursts of up to burst traces.
+func RateLimit(perSecond float64, burst int) Rule {
+	l := &rateLimiter{
+		rate:    perSecond,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+	return l.allow
+}
+
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) allow(t *Trace) bool {
+	var name string
+	if len(t.Roots) > 0 {
+		name = t.Roots[0].Span.Name
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[name]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[name] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,101 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+func TestTailSampler(t *testing.T) {
+	rec := &recordingExporter{}
+	s := &TailSampler{
+		Downstream: rec,
+		Rules:      []Rule{AnyError(), MinDuration(100 * time.Millisecond)},
+	}
+
+	plain := BuildTraces([]*trace.SpanData{
+		testSpan(1, 0, "root", 0),
+		testSpan(2, 1, "child", time.Millisecond),
+	})[0]
+	s.ExportTrace(plain)
+	if len(rec.spans) != 0 {
+		t.Fatalf("uninteresting trace exported %d spans", len(rec.spans))
+	}
+
+	failed := testSpan(2, 1, "child", time.Millisecond)
+	failed.Status.Code = trace.StatusCodeInternal
+	s.ExportTrace(BuildTraces([]*trace.SpanData{testSpan(1, 0, "root", 0), failed})[0])
+	if len(rec.spans) != 2 {
+		t.Fatalf("failed trace exported %d spans; want 2", len(rec.spans))
+	}
+
+	slow := testSpan(2, 1, "child", 200*time.Millisecond)
+	s.ExportTrace(BuildTraces([]*trace.SpanData{testSpan(1, 0, "root", 0), slow})[0])
+	if len(rec.spans) != 4 {
+		t.Fatalf("slow trace exported %d spans; want 2", len(rec.spans)-2)
+	}
+}
+
+func TestTailSamplerImmediateError(t *testing.T) {
+	rec := &recordingExporter{}
+	s := &TailSampler{Downstream: rec, Rules: []Rule{AnyError()}, MaxPendingTraces: 1}
+
+	// Under policy.Default the failed child is exported immediately and
+	// the trace reaching the aggregation point only holds the OK spans.
+	failed := testSpan(2, 1, "child", time.Millisecond)
+	failed.Status.Code = trace.StatusCodeInternal
+	s.ExportSpan(failed)
+	if len(rec.spans) != 1 {
+		t.Fatalf("immediate span was not passed on")
+	}
+	s.ExportTrace(BuildTraces([]*trace.SpanData{testSpan(1, 0, "root", 0)})[0])
+	if len(rec.spans) != 2 {
+		t.Fatalf("trace with an immediately exported error exported %d spans; want 1", len(rec.spans)-1)
+	}
+
+	// The failed span is forgotten once its trace was evaluated.
+	s.ExportTrace(BuildTraces([]*trace.SpanData{testSpan(1, 0, "root", 0)})[0])
+	if len(rec.spans) != 2 {
+		t.Errorf("failed span was remembered after its trace was evaluated")
+	}
+
+	// Only MaxPendingTraces traces are remembered.
+	other := testSpan(2, 1, "child", time.Millisecond)
+	other.TraceID = trace.TraceID{2}
+	s.ExportSpan(failed)
+	s.ExportSpan(other)
+	s.ExportTrace(BuildTraces([]*trace.SpanData{testSpan(1, 0, "root", 0)})[0])
+	if len(rec.spans) != 4 {
+		t.Errorf("error of an evicted trace kept it")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	r := RateLimit(0, 2)
+	a := BuildTraces([]*trace.SpanData{testSpan(1, 0, "a", 0)})[0]
+	b := BuildTraces([]*trace.SpanData{testSpan(1, 0, "b", 0)})[0]
+	if !r(a) || !r(a) {
+		t.Fatalf("traces within burst were not kept")
+	}
+	if r(a) {
+		t.Errorf("trace beyond burst was kept")
+	}
+	if !r(b) {
+		t.Errorf("trace with another root name was not kept")
+	}
+}
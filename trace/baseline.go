@@ -0,0 +1,176 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// baselineAccuracy is the relative accuracy of the latency quantiles
+// returned by LatencyQuantile.
+const baselineAccuracy = 0.01
+
+var (
+	baselinesMu sync.Mutex
+	baselines   = make(map[string]*windowedSketch)
+)
+
+// recordLatencyBaseline adds the latency of a finished span to the baseline
+// of its name, if baselines are enabled with Config.LatencyBaselineWindow.
+func recordLatencyBaseline(sd *SpanData) {
+	window := config.Load().(*Config).LatencyBaselineWindow
+	if window <= 0 {
+		return
+	}
+	baselinesMu.Lock()
+	ws, ok := baselines[sd.Name]
+	if !ok {
+		ws = &windowedSketch{}
+		baselines[sd.Name] = ws
+	}
+	ws.add(sd.EndTime.Sub(sd.StartTime), sd.EndTime, window)
+	baselinesMu.Unlock()
+}
+
+// LatencyQuantile returns the q-quantile, with q between 0 and 1, of the
+// latencies of recently finished spans named name, along with the number of
+// spans it is based on. Latencies are only tracked if
+// Config.LatencyBaselineWindow is set, for spans that are exported or kept
+// in the local span store; they cover the current and the previous window.
+func LatencyQuantile(name string, q float64) (time.Duration, int) {
+	baselinesMu.Lock()
+	defer baselinesMu.Unlock()
+	ws, ok := baselines[name]
+	if !ok {
+		return 0, 0
+	}
+	window := config.Load().(*Config).LatencyBaselineWindow
+	ws.rotate(time.Now(), window)
+	var merged ddSketch
+	merged.merge(&ws.previous)
+	merged.merge(&ws.current)
+	return merged.quantile(q), int(merged.count)
+}
+
+// windowedSketch keeps the latencies of the current and the previous
+// window, so that the baseline follows changes in latency.
+type windowedSketch struct {
+	start             time.Time
+	current, previous ddSketch
+}
+
+func (ws *windowedSketch) rotate(now time.Time, window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	elapsed := now.Sub(ws.start)
+	switch {
+	case elapsed < window:
+		return
+	case elapsed < 2*window:
+		ws.previous = ws.current
+	default:
+		ws.previous = ddSketch{}
+	}
+	ws.current = ddSketch{}
+	ws.start = now
+}
+
+func (ws *windowedSketch) add(d time.Duration, now time.Time, window time.Duration) {
+	ws.rotate(now, window)
+	ws.current.add(d)
+}
+
+// ddSketch is a DDSketch: a quantile sketch with logarithmically sized
+// buckets that guarantees baselineAccuracy relative error.
+type ddSketch struct {
+	bins   []uint64
+	offset int // bucket index of bins[0]
+	zeros  uint64
+	count  uint64
+}
+
+var (
+	ddGamma    = (1 + baselineAccuracy) / (1 - baselineAccuracy)
+	ddLogGamma = math.Log(ddGamma)
+)
+
+func ddIndex(v float64) int {
+	return int(math.Ceil(math.Log(v) / ddLogGamma))
+}
+
+func (s *ddSketch) add(d time.Duration) {
+	s.addCount(d, 1)
+}
+
+func (s *ddSketch) addCount(d time.Duration, n uint64) {
+	s.count += n
+	if d <= 0 {
+		s.zeros += n
+		return
+	}
+	s.addBin(ddIndex(float64(d)), n)
+}
+
+func (s *ddSketch) addBin(i int, n uint64) {
+	switch {
+	case len(s.bins) == 0:
+		s.bins = []uint64{0}
+		s.offset = i
+	case i < s.offset:
+		grown := make([]uint64, len(s.bins)+s.offset-i)
+		copy(grown[s.offset-i:], s.bins)
+		s.bins = grown
+		s.offset = i
+	case i >= s.offset+len(s.bins):
+		s.bins = append(s.bins, make([]uint64, i-s.offset-len(s.bins)+1)...)
+	}
+	s.bins[i-s.offset] += n
+}
+
+func (s *ddSketch) merge(o *ddSketch) {
+	s.count += o.count
+	s.zeros += o.zeros
+	for j, n := range o.bins {
+		if n > 0 {
+			s.addBin(o.offset+j, n)
+		}
+	}
+}
+
+func (s *ddSketch) quantile(q float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+	rank := uint64(q * float64(s.count-1))
+	seen := s.zeros
+	if rank < seen {
+		return 0
+	}
+	for j, n := range s.bins {
+		seen += n
+		if rank < seen {
+			return time.Duration(2 * math.Pow(ddGamma, float64(s.offset+j)) / (ddGamma + 1))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDDSketchQuantile(t *testing.T) {
+	var s ddSketch
+	for i := 1; i <= 1000; i++ {
+		s.add(time.Duration(i) * time.Millisecond)
+	}
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+		want := float64(1+int(q*999)) * float64(time.Millisecond)
+		got := float64(s.quantile(q))
+		if math.Abs(got-want)/want > baselineAccuracy {
+			t.Errorf("quantile(%v) = %v; want %v within %v", q, time.Duration(got), time.Duration(want), baselineAccuracy)
+		}
+	}
+}
+
+func TestWindowedSketchRotation(t *testing.T) {
+	var ws windowedSketch
+	now := time.Now()
+	ws.add(time.Second, now, time.Minute)
+	ws.add(time.Second, now.Add(90*time.Second), time.Minute)
+	if got := ws.previous.count + ws.current.count; got != 2 {
+		t.Errorf("after one window: count = %d; want 2", got)
+	}
+	ws.add(time.Second, now.Add(10*time.Minute), time.Minute)
+	if got := ws.previous.count + ws.current.count; got != 1 {
+		t.Errorf("after idle windows: count = %d; want 1", got)
+	}
+}
+
+func TestLatencyQuantile(t *testing.T) {
+	old := config.Load()
+	defer config.Store(old)
+	ApplyConfig(Config{LatencyBaselineWindow: time.Minute})
+
+	start := time.Now()
+	for i := 1; i <= 100; i++ {
+		recordLatencyBaseline(&SpanData{
+			Name:      "baseline",
+			StartTime: start,
+			EndTime:   start.Add(time.Duration(i) * time.Millisecond),
+		})
+	}
+	got, n := LatencyQuantile("baseline", 0.5)
+	if n != 100 {
+		t.Errorf("LatencyQuantile() count = %d; want 100", n)
+	}
+	if got < 49*time.Millisecond || got > 52*time.Millisecond {
+		t.Errorf("LatencyQuantile(0.5) = %v; want about 50ms", got)
+	}
+	if _, n := LatencyQuantile("unknown", 0.5); n != 0 {
+		t.Errorf("LatencyQuantile() of unknown span count = %d; want 0", n)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of BatchSpanProcessorOptions.
+const (
+	DefaultMaxQueueSize       = 2048
+	DefaultMaxExportBatchSize = 512
+	DefaultBatchTimeout       = 5 * time.Second
+)
+
+// DropPolicy decides what a BatchSpanProcessor does with a span when its
+// queue is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the span being ended.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest queued span to make room.
+	DropOldest
+	// BlockWhenFull blocks the goroutine ending the span until there is
+	// room, or the processor is shut down.
+	BlockWhenFull
+)
+
+// BatchSpanProcessorOptions configures a BatchSpanProcessor.
+type BatchSpanProcessorOptions struct {
+	// MaxQueueSize is the number of spans that can wait to be exported.
+	// Defaults to DefaultMaxQueueSize.
+	MaxQueueSize int
+
+	// MaxExportBatchSize is the number of spans exported together. A batch
+	// is exported as soon as that many spans are queued. Defaults to
+	// DefaultMaxExportBatchSize.
+	MaxExportBatchSize int
+
+	// BatchTimeout is the longest a span waits in the queue before it is
+	// exported. Defaults to DefaultBatchTimeout.
+	BatchTimeout time.Duration
+
+	// DropPolicy applies when the queue is full. Defaults to DropNewest.
+	DropPolicy DropPolicy
+}
+
+// BatchSpanProcessor is an Exporter that queues spans and exports them to
+// another Exporter in batches from a background goroutine, so that a slow
+// exporter does not delay the requests ending spans. Register it in place
+// of the exporter it wraps.
+//
+// Only ExportSpan calls are queued; wrap exporters that piggyback spans
+// with care, as their AggregatingExporter methods are not forwarded.
+type BatchSpanProcessor struct {
+	e Exporter
+	o BatchSpanProcessorOptions
+
+	mu     sync.Mutex
+	cond   *sync.Cond // signaled when the queue shrinks or is closed
+	queue  []*SpanData
+	closed bool
+
+	dropped int64 // accessed atomically
+
+	wake     chan struct{}
+	flushc   chan chan struct{}
+	stopc    chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+var (
+	_ Exporter = (*BatchSpanProcessor)(nil)
+	_ Flusher  = (*BatchSpanProcessor)(nil)
+)
+
+// NewBatchSpanProcessor returns a BatchSpanProcessor exporting to e and
+// starts its background goroutine. Call Shutdown to stop it.
+func NewBatchSpanProcessor(e Exporter, o BatchSpanProcessorOptions) *BatchSpanProcessor {
+	if o.MaxQueueSize <= 0 {
+		o.MaxQueueSize = DefaultMaxQueueSize
+	}
+	if o.MaxExportBatchSize <= 0 {
+		o.MaxExportBatchSize = DefaultMaxExportBatchSize
+	}
+	if o.MaxExportBatchSize > o.MaxQueueSize {
+		o.MaxExportBatchSize = o.MaxQueueSize
+	}
+	if o.BatchTimeout <= 0 {
+		o.BatchTimeout = DefaultBatchTimeout
+	}
+	p := &BatchSpanProcessor{
+		e:      e,
+		o:      o,
+		wake:   make(chan struct{}, 1),
+		flushc: make(chan chan struct{}),
+		stopc:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	go p.loop()
+	return p
+}
+
+// ExportSpan queues sd to be exported, applying the DropPolicy if the queue
+// is full. Spans ended after Shutdown are dropped.
+func (p *BatchSpanProcessor) ExportSpan(sd *SpanData) {
+	p.mu.Lock()
+	for !p.closed && len(p.queue) >= p.o.MaxQueueSize && p.o.DropPolicy == BlockWhenFull {
+		p.cond.Wait()
+	}
+	switch {
+	case p.closed:
+		p.mu.Unlock()
+		atomic.AddInt64(&p.dropped, 1)
+		return
+	case len(p.queue) >= p.o.MaxQueueSize:
+		if p.o.DropPolicy != DropOldest {
+			p.mu.Unlock()
+			atomic.AddInt64(&p.dropped, 1)
+			return
+		}
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		atomic.AddInt64(&p.dropped, 1)
+	}
+	p.queue = append(p.queue, sd)
+	full := len(p.queue) >= p.o.MaxExportBatchSize
+	p.mu.Unlock()
+	if full {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// DroppedSpans returns the number of spans dropped because the queue was
+// full or the processor was shut down.
+func (p *BatchSpanProcessor) DroppedSpans() int64 {
+	return atomic.LoadInt64(&p.dropped)
+}
+
+// QueueDepth returns the number of spans waiting to be exported.
+func (p *BatchSpanProcessor) QueueDepth() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// Flush exports all queued spans and waits until they have been passed to
+// the wrapped exporter, or until ctx is done. The wrapped exporter is then
+// flushed too if it is a Flusher.
+func (p *BatchSpanProcessor) Flush(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case p.flushc <- reply:
+		select {
+		case <-reply:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if f, ok := p.e.(Flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
+// Shutdown stops accepting spans, exports the queued ones and stops the
+// background goroutine. It waits until that is done, or until ctx is done.
+func (p *BatchSpanProcessor) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		p.mu.Lock()
+		p.closed = true
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		close(p.stopc)
+	})
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *BatchSpanProcessor) loop() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.o.BatchTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.wake:
+			p.export(false)
+		case <-ticker.C:
+			p.export(true)
+		case reply := <-p.flushc:
+			p.export(true)
+			close(reply)
+		case <-p.stopc:
+			p.export(true)
+			return
+		}
+	}
+}
+
+// export exports the queued spans in batches, leaving a partial batch in
+// the queue unless all is set.
+func (p *BatchSpanProcessor) export(all bool) {
+	for {
+		p.mu.Lock()
+		n := len(p.queue)
+		if n > p.o.MaxExportBatchSize {
+			n = p.o.MaxExportBatchSize
+		}
+		if n == 0 || (!all && n < p.o.MaxExportBatchSize) {
+			p.mu.Unlock()
+			return
+		}
+		batch := make([]*SpanData, n)
+		copy(batch, p.queue)
+		for i := range p.queue[:n] {
+			p.queue[i] = nil
+		}
+		p.queue = p.queue[n:]
+		p.cond.Broadcast()
+		p.mu.Unlock()
+
+		for _, sd := range batch {
+			p.e.ExportSpan(sd)
+		}
+	}
+}
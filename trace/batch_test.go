@@ -0,0 +1,192 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedExporter blocks in ExportSpan until its gate is opened.
+type gatedExporter struct {
+	gate chan struct{}
+
+	mu    sync.Mutex
+	names []string
+}
+
+func (e *gatedExporter) ExportSpan(sd *SpanData) {
+	if e.gate != nil {
+		<-e.gate
+	}
+	e.mu.Lock()
+	e.names = append(e.names, sd.Name)
+	e.mu.Unlock()
+}
+
+func (e *gatedExporter) exported() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.names...)
+}
+
+func namedSpan(name string) *SpanData {
+	return &SpanData{Name: name}
+}
+
+func TestBatchSpanProcessorBatches(t *testing.T) {
+	e := &gatedExporter{}
+	p := NewBatchSpanProcessor(e, BatchSpanProcessorOptions{
+		MaxExportBatchSize: 2,
+		BatchTimeout:       time.Hour,
+	})
+	defer p.Shutdown(context.Background())
+
+	p.ExportSpan(namedSpan("a"))
+	p.ExportSpan(namedSpan("b"))
+	p.ExportSpan(namedSpan("c"))
+	deadline := time.Now().Add(time.Second)
+	for len(e.exported()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := e.exported(); len(got) != 2 {
+		t.Fatalf("exported %v after a full batch; want [a b]", got)
+	}
+	if got := p.QueueDepth(); got != 1 {
+		t.Errorf("QueueDepth() = %d; want 1", got)
+	}
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	if got := e.exported(); len(got) != 3 || got[2] != "c" {
+		t.Errorf("exported %v after Flush; want [a b c]", got)
+	}
+}
+
+func TestBatchSpanProcessorTimeout(t *testing.T) {
+	e := &gatedExporter{}
+	p := NewBatchSpanProcessor(e, BatchSpanProcessorOptions{BatchTimeout: 10 * time.Millisecond})
+	defer p.Shutdown(context.Background())
+
+	p.ExportSpan(namedSpan("a"))
+	deadline := time.Now().Add(time.Second)
+	for len(e.exported()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := e.exported(); len(got) != 1 {
+		t.Errorf("exported %v after the batch timeout; want [a]", got)
+	}
+}
+
+func TestBatchSpanProcessorDropPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy DropPolicy
+		want   []string
+	}{
+		{DropNewest, []string{"a", "b"}},
+		{DropOldest, []string{"c", "d"}},
+	} {
+		e := &gatedExporter{}
+		p := NewBatchSpanProcessor(e, BatchSpanProcessorOptions{
+			MaxQueueSize:       2,
+			MaxExportBatchSize: 2,
+			BatchTimeout:       time.Hour,
+			DropPolicy:         tt.policy,
+		})
+		// Keep the queue from being drained until all spans are ended.
+		p.mu.Lock()
+		p.o.MaxExportBatchSize = 3
+		p.mu.Unlock()
+		for _, name := range []string{"a", "b", "c", "d"} {
+			p.ExportSpan(namedSpan(name))
+		}
+		if got := p.DroppedSpans(); got != 2 {
+			t.Errorf("policy %d: DroppedSpans() = %d; want 2", tt.policy, got)
+		}
+		if err := p.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown() error: %v", err)
+		}
+		got := e.exported()
+		if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("policy %d: exported %v; want %v", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestBatchSpanProcessorBlockWhenFull(t *testing.T) {
+	e := &gatedExporter{gate: make(chan struct{})}
+	p := NewBatchSpanProcessor(e, BatchSpanProcessorOptions{
+		MaxQueueSize:       1,
+		MaxExportBatchSize: 1,
+		BatchTimeout:       time.Hour,
+		DropPolicy:         BlockWhenFull,
+	})
+	p.ExportSpan(namedSpan("a")) // taken by the exporter, which blocks
+	deadline := time.Now().Add(time.Second)
+	for p.QueueDepth() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	p.ExportSpan(namedSpan("b")) // fills the queue
+
+	ended := make(chan struct{})
+	go func() {
+		p.ExportSpan(namedSpan("c"))
+		close(ended)
+	}()
+	select {
+	case <-ended:
+		t.Fatal("ExportSpan returned with a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(e.gate)
+	<-ended
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+	if got := e.exported(); len(got) != 3 {
+		t.Errorf("exported %v; want [a b c]", got)
+	}
+	if got := p.DroppedSpans(); got != 0 {
+		t.Errorf("DroppedSpans() = %d; want 0", got)
+	}
+}
+
+func TestBatchSpanProcessorShutdown(t *testing.T) {
+	e := &gatedExporter{gate: make(chan struct{})}
+	p := NewBatchSpanProcessor(e, BatchSpanProcessorOptions{BatchTimeout: time.Hour})
+	p.ExportSpan(namedSpan("a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() with a blocked exporter error = %v; want DeadlineExceeded", err)
+	}
+	close(e.gate)
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+	if got := e.exported(); len(got) != 1 {
+		t.Errorf("exported %v; want the span queued before Shutdown", got)
+	}
+	p.ExportSpan(namedSpan("late"))
+	if got := p.DroppedSpans(); got != 1 {
+		t.Errorf("DroppedSpans() after Shutdown = %d; want 1", got)
+	}
+	if err := p.Flush(context.Background()); err != nil {
+		t.Errorf("Flush() after Shutdown error: %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MergedSpanCountAttribute is set on an aggregated span that stands in for
+// several sibling spans of the same name merged to fit the header budget.
+// Its value is the number of spans merged.
+const MergedSpanCountAttribute = "agg.merged_count"
+
+// droppedEntryPrefix starts the AggregationHeader value that reports how
+// many spans were dropped to fit the header budget. It cannot be mistaken
+// for a value produced by any of the codecs.
+const droppedEntryPrefix = "dropped="
+
+// aggregationEntryOverhead approximates the bytes a header line adds on top
+// of its value, e.g. "Agg: " and the trailing CRLF.
+const aggregationEntryOverhead = len(AggregationHeader) + 4
+
+// ParseDroppedEntry reports whether value is the entry recording spans
+// dropped to fit Config.MaxAggregationHeaderBytes, and if so how many.
+func ParseDroppedEntry(value string) (int, bool) {
+	if !strings.HasPrefix(value, droppedEntryPrefix) {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value[len(droppedEntryPrefix):])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func makeDroppedEntry(n int) string {
+	return droppedEntryPrefix + strconv.Itoa(n)
+}
+
+func aggregationSize(values []string) int {
+	size := 0
+	for _, v := range values {
+		size += len(v) + aggregationEntryOverhead
+	}
+	return size
+}
+
+type aggregationEntry struct {
+	value string
+	// sd is nil if value could not be decoded.
+	sd *SpanData
+}
+
+// complete reports whether the entry carries a complete span. Complete
+// spans are always sampled, while compact ones carry no trace options.
+func (e *aggregationEntry) complete() bool {
+	return e.sd != nil && e.sd.TraceOptions.IsSampled()
+}
+
+// priority orders entries for dropping, lowest first.
+func (e *aggregationEntry) priority() int {
+	switch {
+	case e.sd == nil:
+		return 0
+	case e.complete():
+		return 2
+	}
+	return 1
+}
+
+// fitAggregationBudget shrinks values so that they take at most budget bytes
+// on the wire. Compact sibling spans sharing a name are merged first; if that
+// is not enough, the lowest priority entries are dropped, shortest first, and
+// a single dropped entry records how many spans were lost, including those
+// reported as dropped by downstream calls.
+func fitAggregationBudget(values []string, codec PiggybackCodec, budget int) []string {
+	if budget <= 0 || aggregationSize(values) <= budget {
+		return values
+	}
+
+	dropped := 0
+	entries := make([]*aggregationEntry, 0, len(values))
+	for _, v := range values {
+		if n, ok := ParseDroppedEntry(v); ok {
+			dropped += n
+			continue
+		}
+		e := &aggregationEntry{value: v}
+		if sd, err := codec.Decode(v); err == nil {
+			e.sd = sd
+		}
+		entries = append(entries, e)
+	}
+
+	// Merged spans may have made siblings of their children, which may
+	// in turn be merged.
+	for n := 0; n != len(entries); {
+		n = len(entries)
+		entries = mergeSiblingEntries(entries, codec)
+	}
+	size := func() int {
+		s := 0
+		for _, e := range entries {
+			s += len(e.value) + aggregationEntryOverhead
+		}
+		if dropped > 0 {
+			s += len(makeDroppedEntry(dropped)) + aggregationEntryOverhead
+		}
+		return s
+	}
+
+	if size() > budget {
+		sort.SliceStable(entries, func(i, j int) bool {
+			pi, pj := entries[i].priority(), entries[j].priority()
+			if pi != pj {
+				return pi < pj
+			}
+			if entries[i].sd == nil {
+				return false
+			}
+			return spanDuration(entries[i].sd) < spanDuration(entries[j].sd)
+		})
+		for len(entries) > 0 && size() > budget {
+			dropped += entryCount(entries[0])
+			entries = entries[1:]
+		}
+	}
+
+	out := make([]string, 0, len(entries)+1)
+	for _, e := range entries {
+		out = append(out, e.value)
+	}
+	if dropped > 0 {
+		out = append(out, makeDroppedEntry(dropped))
+	}
+	return out
+}
+
+// mergeSiblingEntries replaces compact spans that share a trace, parent and
+// name with a single compact span covering all of them, annotated with
+// MergedSpanCountAttribute. The children of the merged spans are moved under
+// the merged span. Groups whose merged span would not be smaller are left to
+// be dropped.
+func mergeSiblingEntries(entries []*aggregationEntry, codec PiggybackCodec) []*aggregationEntry {
+	groups := make(map[siblingKey][]*aggregationEntry)
+	for _, e := range entries {
+		if e.sd == nil || e.complete() {
+			continue
+		}
+		k := siblingKey{e.sd.TraceID, e.sd.ParentSpanID, e.sd.Name}
+		groups[k] = append(groups[k], e)
+	}
+
+	merged := make(map[*aggregationEntry]*aggregationEntry)
+	reparent := make(map[SpanID]SpanID)
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		first := group[0].sd
+		sd := &SpanData{
+			SpanContext:  SpanContext{TraceID: first.TraceID, SpanID: first.SpanID},
+			ParentSpanID: first.ParentSpanID,
+			Name:         first.Name,
+			StartTime:    first.StartTime,
+			EndTime:      first.EndTime,
+		}
+		count := 0
+		for _, e := range group {
+			if e.sd.StartTime.Before(sd.StartTime) {
+				sd.StartTime = e.sd.StartTime
+			}
+			if e.sd.EndTime.After(sd.EndTime) {
+				sd.EndTime = e.sd.EndTime
+			}
+			count += entryCount(e)
+		}
+		sd.Attributes = map[string]interface{}{MergedSpanCountAttribute: int64(count)}
+		v, err := encodeCompactEntry(sd, codec)
+		if err != nil || !smallerThan(v, group) {
+			continue
+		}
+		m := &aggregationEntry{value: v, sd: sd}
+		for _, e := range group {
+			merged[e] = m
+			reparent[e.sd.SpanID] = sd.SpanID
+		}
+	}
+	if len(merged) == 0 {
+		return entries
+	}
+
+	out := entries[:0]
+	emitted := make(map[*aggregationEntry]bool)
+	for _, e := range entries {
+		m, ok := merged[e]
+		if !ok {
+			out = append(out, e)
+			continue
+		}
+		if !emitted[m] {
+			emitted[m] = true
+			out = append(out, m)
+		}
+	}
+	for _, e := range out {
+		if e.sd == nil {
+			continue
+		}
+		if p, ok := reparent[e.sd.ParentSpanID]; ok && p != e.sd.ParentSpanID {
+			e.sd.ParentSpanID = p
+			if v, err := reencodeEntry(e.sd, codec); err == nil {
+				e.value = v
+			}
+		}
+	}
+	return out
+}
+
+// encodeCompactEntry encodes sd in the compact form, carrying its status,
+// kind and all of its attributes.
+func encodeCompactEntry(sd *SpanData, codec PiggybackCodec) (string, error) {
+	schema := CompactSchema{Status: true, Kind: true}
+	for k := range sd.Attributes {
+		schema.Attributes = append(schema.Attributes, k)
+	}
+	sort.Strings(schema.Attributes)
+	return codec.EncodeCompact(sd, schema)
+}
+
+// smallerThan reports whether value takes fewer bytes on the wire than the
+// entries it replaces.
+func smallerThan(value string, group []*aggregationEntry) bool {
+	size := 0
+	for _, e := range group {
+		size += len(e.value) + aggregationEntryOverhead
+	}
+	return len(value)+aggregationEntryOverhead < size
+}
+
+// entryCount returns the number of spans an entry stands for.
+func entryCount(e *aggregationEntry) int {
+	if e.sd != nil {
+		switch n := e.sd.Attributes[MergedSpanCountAttribute].(type) {
+		case int64:
+			return int(n)
+		case float64:
+			// JSON decodes numbers as float64.
+			return int(n)
+		}
+	}
+	return 1
+}
+
+func spanDuration(sd *SpanData) int64 {
+	return int64(sd.EndTime.Sub(sd.StartTime))
+}
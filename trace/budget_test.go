@@ -0,0 +1,173 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"testing"
+	"time"
+)
+
+func encodeTestSpans(t *testing.T, codec PiggybackCodec, n int, name string, parent SpanID) []string {
+	var values []string
+	start := time.UnixMicro(1600000000000000)
+	for i := 0; i < n; i++ {
+		sd := &SpanData{
+			SpanContext:  SpanContext{TraceID: TraceID{1}, SpanID: SpanID{byte(i + 1), 1}},
+			ParentSpanID: parent,
+			Name:         name,
+			StartTime:    start,
+			EndTime:      start.Add(time.Duration(i+1) * time.Millisecond),
+		}
+		v, err := codec.EncodeServerless(sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestFitAggregationBudgetUnderBudget(t *testing.T) {
+	codec := BinaryCodec()
+	values := encodeTestSpans(t, codec, 3, "child", SpanID{9})
+	if got := fitAggregationBudget(values, codec, 0); len(got) != 3 {
+		t.Errorf("no budget: got %d entries; want 3", len(got))
+	}
+	if got := fitAggregationBudget(values, codec, aggregationSize(values)); len(got) != 3 {
+		t.Errorf("exact budget: got %d entries; want 3", len(got))
+	}
+}
+
+func TestFitAggregationBudgetMergesSiblings(t *testing.T) {
+	codec := BinaryCodec()
+	values := encodeTestSpans(t, codec, 20, "child", SpanID{9})
+	values = append(values, encodeTestSpans(t, codec, 1, "other", SpanID{9})...)
+
+	got := fitAggregationBudget(values, codec, aggregationSize(values)/2)
+	if len(got) != 2 {
+		t.Fatalf("got %d entries; want 2", len(got))
+	}
+	sd, err := codec.Decode(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.Name != "child" {
+		t.Errorf("merged span name = %q; want %q", sd.Name, "child")
+	}
+	if n := sd.Attributes[MergedSpanCountAttribute]; n != int64(20) {
+		t.Errorf("merged count = %v; want 20", n)
+	}
+	if d := sd.EndTime.Sub(sd.StartTime); d != 20*time.Millisecond {
+		t.Errorf("merged duration = %v; want 20ms", d)
+	}
+}
+
+func TestFitAggregationBudgetDrops(t *testing.T) {
+	codec := BinaryCodec()
+	var values []string
+	for i := 0; i < 10; i++ {
+		values = append(values, encodeTestSpans(t, codec, 1, "span", SpanID{byte(i + 1)})...)
+	}
+	values = append(values, makeDroppedEntry(4))
+
+	budget := aggregationSize(values[:5])
+	got := fitAggregationBudget(values, codec, budget)
+	if size := aggregationSize(got); size > budget {
+		t.Errorf("size = %d; want at most %d", size, budget)
+	}
+	dropped, ok := ParseDroppedEntry(got[len(got)-1])
+	if !ok {
+		t.Fatalf("last entry %q is not a dropped entry", got[len(got)-1])
+	}
+	if want := 4 + 10 - (len(got) - 1); dropped != want {
+		t.Errorf("dropped = %d; want %d", dropped, want)
+	}
+	// The shortest spans go first, so the first span must be gone.
+	for _, v := range got[:len(got)-1] {
+		if v == values[0] {
+			t.Errorf("shortest span was kept")
+		}
+	}
+}
+
+func TestFitAggregationBudgetJustBelowSize(t *testing.T) {
+	for name, codec := range testCodecs {
+		values := encodeTestSpans(t, codec, 3, "child", SpanID{9})
+		smallest := aggregationSize(values[:1]) + aggregationSize([]string{makeDroppedEntry(2)})
+		for budget := aggregationSize(values) - 1; budget >= smallest; budget -= 20 {
+			got := fitAggregationBudget(values, codec, budget)
+			if size := aggregationSize(got); size > budget {
+				t.Errorf("%s: budget %d: size = %d", name, budget, size)
+			}
+			spans, count := 0, 0
+			for _, v := range got {
+				if n, ok := ParseDroppedEntry(v); ok {
+					count += n
+					continue
+				}
+				sd, err := codec.Decode(v)
+				if err != nil {
+					t.Fatalf("%s: Decode(%q) error: %v", name, v, err)
+				}
+				spans++
+				count += entryCount(&aggregationEntry{value: v, sd: sd})
+			}
+			if spans == 0 {
+				t.Errorf("%s: budget %d: got %v; want at least one span kept", name, budget, got)
+			}
+			if count != 3 {
+				t.Errorf("%s: budget %d: got %v accounting for %d spans; want 3", name, budget, got, count)
+			}
+		}
+	}
+}
+
+func TestFitAggregationBudgetReparentsMergedChildren(t *testing.T) {
+	for name, codec := range testCodecs {
+		encode := func(sd *SpanData) string {
+			v, err := codec.EncodeServerless(sd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+		values := []string{
+			encode(fanOutSpan(1, 0, "root", 10*time.Millisecond)),
+			encode(fanOutSpan(2, 1, "x", 2*time.Millisecond)),
+			encode(fanOutSpan(3, 1, "x", 3*time.Millisecond)),
+			encode(fanOutSpan(4, 2, "c", time.Millisecond)),
+			encode(fanOutSpan(5, 3, "c", time.Millisecond)),
+		}
+		got := fitAggregationBudget(values, codec, aggregationSize(values)-1)
+		ids := make(map[SpanID]bool)
+		var spans []*SpanData
+		for _, v := range got {
+			if _, ok := ParseDroppedEntry(v); ok {
+				continue
+			}
+			sd, err := codec.Decode(v)
+			if err != nil {
+				t.Fatalf("%s: Decode(%q) error: %v", name, v, err)
+			}
+			ids[sd.SpanID] = true
+			spans = append(spans, sd)
+		}
+		for _, sd := range spans {
+			if sd.ParentSpanID != (SpanID{}) && !ids[sd.ParentSpanID] {
+				t.Errorf("%s: %s points at missing parent %v in %v", name, sd.Name, sd.ParentSpanID, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AggregationCapabilities is what a caller advertises in the
+// AggregationAcceptHeader: the codecs it decodes and the largest
+// AggregationHeader trailer it accepts. The header value lists the codec
+// names followed by an optional size limit, as in "json/1, binary/1;
+// max=4096".
+type AggregationCapabilities struct {
+	// Codecs holds the names of the accepted codecs, as returned by
+	// PiggybackCodec.Name.
+	Codecs []string
+
+	// MaxBytes bounds the size of the AggregationHeader values of the
+	// response. Zero means no limit.
+	MaxBytes int
+}
+
+// LocalAggregationCapabilities returns the capabilities of this process:
+// the configured PiggybackCodec and MaxAggregationHeaderBytes.
+func LocalAggregationCapabilities() AggregationCapabilities {
+	cfg := config.Load().(*Config)
+	return AggregationCapabilities{
+		Codecs:   []string{cfg.PiggybackCodec.Name()},
+		MaxBytes: cfg.MaxAggregationHeaderBytes,
+	}
+}
+
+// String formats c as an AggregationAcceptHeader value.
+func (c AggregationCapabilities) String() string {
+	s := strings.Join(c.Codecs, ", ")
+	if c.MaxBytes > 0 {
+		s += "; max=" + strconv.Itoa(c.MaxBytes)
+	}
+	return s
+}
+
+// Accepts reports whether codec is one of the accepted codecs.
+func (c AggregationCapabilities) Accepts(codec PiggybackCodec) bool {
+	name := codec.Name()
+	for _, n := range c.Codecs {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAggregationCapabilities parses an AggregationAcceptHeader value.
+func ParseAggregationCapabilities(s string) (AggregationCapabilities, error) {
+	var c AggregationCapabilities
+	parts := strings.Split(s, ";")
+	for _, name := range strings.Split(parts[0], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			c.Codecs = append(c.Codecs, name)
+		}
+	}
+	if len(c.Codecs) == 0 {
+		return c, fmt.Errorf("trace: no codecs in aggregation capabilities %q", s)
+	}
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 || kv[0] != "max" {
+			// Unknown parameters are left to later versions.
+			continue
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("trace: invalid trailer size in aggregation capabilities %q", s)
+		}
+		c.MaxBytes = n
+	}
+	return c, nil
+}
+
+// RequestAggregationCapabilities returns the capabilities advertised by the
+// caller that sent r. ok is false if r is nil or carries no valid
+// AggregationAcceptHeader.
+func RequestAggregationCapabilities(r *http.Request) (c AggregationCapabilities, ok bool) {
+	if r == nil {
+		return c, false
+	}
+	v := r.Header.Get(AggregationAcceptHeader)
+	if v == "" {
+		return c, false
+	}
+	c, err := ParseAggregationCapabilities(v)
+	return c, err == nil
+}
+
+// AcceptsAggregation reports whether the caller that sent r advertised
+// support for the configured PiggybackCodec.
+func AcceptsAggregation(r *http.Request) bool {
+	c, ok := RequestAggregationCapabilities(r)
+	return ok && c.Accepts(config.Load().(*Config).PiggybackCodec)
+}
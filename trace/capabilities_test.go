@@ -0,0 +1,94 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseAggregationCapabilities(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    AggregationCapabilities
+		wantErr bool
+	}{
+		{"json/1", AggregationCapabilities{Codecs: []string{"json/1"}}, false},
+		{"json/1, binary/1; max=4096", AggregationCapabilities{Codecs: []string{"json/1", "binary/1"}, MaxBytes: 4096}, false},
+		{"proto/1; future=x", AggregationCapabilities{Codecs: []string{"proto/1"}}, false},
+		{"", AggregationCapabilities{}, true},
+		{"json/1; max=-1", AggregationCapabilities{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAggregationCapabilities(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseAggregationCapabilities(%q) error = %v; want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseAggregationCapabilities(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+		if !tt.wantErr {
+			if again, _ := ParseAggregationCapabilities(got.String()); !reflect.DeepEqual(again, got) {
+				t.Errorf("%q does not round trip: got %+v", got.String(), again)
+			}
+		}
+	}
+}
+
+func TestEndAndAggregateHonorsCapabilities(t *testing.T) {
+	piggyback := &piggybackExporter{}
+	RegisterExporter(piggyback)
+	defer UnregisterExporter(piggyback)
+
+	end := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		if accept != "" {
+			r.Header.Set(AggregationAcceptHeader, accept)
+		}
+		_, span := StartSpan(context.Background(), "a-rather-long-span-name", WithSampler(AlwaysSample()))
+		w := httptest.NewRecorder()
+		span.EndAndAggregate(w, r)
+		return w
+	}
+
+	if got := len(end("json/1").Header()[AggregationHeader]); got != 1 {
+		t.Errorf("accepted codec: got %d aggregated spans; want 1", got)
+	}
+	if got := len(end("").Header()[AggregationHeader]); got != 0 {
+		t.Errorf("no capabilities: got %d aggregated spans; want 0", got)
+	}
+	if len(piggyback.spans) != 1 {
+		t.Errorf("no capabilities: got %d exported spans; want 1", len(piggyback.spans))
+	}
+
+	w := end("binary/1")
+	if got := len(w.Header()[AggregationHeader]); got != 0 {
+		t.Errorf("unaccepted codec: got %d aggregated spans; want 0", got)
+	}
+	if len(piggyback.spans) != 2 {
+		t.Errorf("unaccepted codec: got %d exported spans; want 1", len(piggyback.spans)-1)
+	}
+
+	values := end("json/1; max=20").Header()[AggregationHeader]
+	if len(values) != 1 {
+		t.Fatalf("size limit: got %d values; want a dropped-span entry", len(values))
+	}
+	if n, ok := ParseDroppedEntry(values[0]); !ok || n != 1 {
+		t.Errorf("size limit: got %q; want one dropped span", values[0])
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CompactSchemaVersion is the version of the compact span schema written by
+// PiggybackCodec.EncodeCompact. Version 1 carried the ServerlessSpanData
+// fields only; version 2 adds the optional status, kind and attributes
+// selected by a CompactSchema. Codecs reject compact spans of later
+// versions with ErrUnsupportedCompactVersion.
+const CompactSchemaVersion = 2
+
+// ErrUnsupportedCompactVersion is returned, wrapped, when decoding a compact
+// span written with a schema version this package does not know.
+var ErrUnsupportedCompactVersion = errors.New("trace: unsupported compact span schema version")
+
+func checkCompactVersion(v int) error {
+	if v < 1 || v > CompactSchemaVersion {
+		return fmt.Errorf("%w %d", ErrUnsupportedCompactVersion, v)
+	}
+	return nil
+}
+
+// CompactSchema selects the optional fields of compact spans. The IDs,
+// name, times, invocation attributes and whether the span has a remote
+// parent are always carried.
+type CompactSchema struct {
+	// Status carries the status code and message.
+	Status bool
+
+	// Kind carries the span kind.
+	Kind bool
+
+	// Attributes lists the keys of the attributes to carry, such as
+	// "http.status_code".
+	Attributes []string
+}
+
+// CompactSchemaExporter is implemented by AggregatingExporters that choose
+// the optional fields of the compact spans piggybacked for them. Other
+// exporters get the zero CompactSchema. The status is carried regardless
+// when Config.SummarizeSiblings is set.
+type CompactSchemaExporter interface {
+	AggregatingExporter
+
+	// CompactSchema returns the fields of compact spans.
+	CompactSchema() CompactSchema
+}
+
+func compactSchemaOf(e AggregatingExporter) CompactSchema {
+	var s CompactSchema
+	if ce, ok := e.(CompactSchemaExporter); ok {
+		s = ce.CompactSchema()
+	}
+	if config.Load().(*Config).SummarizeSiblings > 0 {
+		// Summaries count the failed spans among the siblings.
+		s.Status = true
+	}
+	return s
+}
+
+// view returns the part of sd carried by its compact encoding.
+func (s CompactSchema) view(sd *SpanData) *SpanData {
+	v := &SpanData{
+		SpanContext:     SpanContext{TraceID: sd.TraceID, SpanID: sd.SpanID},
+		ParentSpanID:    sd.ParentSpanID,
+		Name:            sd.Name,
+		StartTime:       sd.StartTime,
+		EndTime:         sd.EndTime,
+		Attributes:      invocationAttributes(sd),
+		HasRemoteParent: sd.HasRemoteParent,
+	}
+	if s.Status {
+		v.Status = sd.Status
+	}
+	if s.Kind {
+		v.SpanKind = sd.SpanKind
+	}
+	for _, k := range s.Attributes {
+		val, ok := sd.Attributes[k]
+		if !ok {
+			continue
+		}
+		switch val.(type) {
+		case string, bool, int64, float64:
+		default:
+			continue
+		}
+		if v.Attributes == nil {
+			v.Attributes = make(map[string]interface{})
+		}
+		v.Attributes[k] = val
+	}
+	return v
+}
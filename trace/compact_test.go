@@ -0,0 +1,154 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var testCodecs = map[string]PiggybackCodec{
+	"binary": BinaryCodec(),
+	"proto":  ProtoCodec(),
+	"json":   JSONCodec(),
+}
+
+func TestCompactSchema(t *testing.T) {
+	sd := testPiggybackSpan()
+	sd.Attributes = map[string]interface{}{
+		"http.status_code":    int64(503),
+		"http.path":           "/hello",
+		"secret":              "s",
+		FunctionNameAttribute: "hello",
+	}
+	schema := CompactSchema{
+		Status:     true,
+		Kind:       true,
+		Attributes: []string{"http.status_code", "http.path", "missing"},
+	}
+	wantAttrs := map[string]interface{}{
+		"http.status_code":    int64(503),
+		"http.path":           "/hello",
+		FunctionNameAttribute: "hello",
+	}
+	for name, codec := range testCodecs {
+		v, err := codec.EncodeCompact(sd, schema)
+		if err != nil {
+			t.Fatalf("%s: EncodeCompact() error: %v", name, err)
+		}
+		got, err := codec.Decode(v)
+		if err != nil {
+			t.Fatalf("%s: Decode(%q) error: %v", name, v, err)
+		}
+		if got.Status != sd.Status || got.SpanKind != sd.SpanKind {
+			t.Errorf("%s: status, kind = %v, %d; want %v, %d", name, got.Status, got.SpanKind, sd.Status, sd.SpanKind)
+		}
+		if !reflect.DeepEqual(got.Attributes, wantAttrs) {
+			t.Errorf("%s: attributes = %v; want %v", name, got.Attributes, wantAttrs)
+		}
+		if got.IsSampled() {
+			t.Errorf("%s: compact span decoded as complete", name)
+		}
+		if got.HasRemoteParent != sd.HasRemoteParent {
+			t.Errorf("%s: HasRemoteParent = %v; want %v", name, got.HasRemoteParent, sd.HasRemoteParent)
+		}
+	}
+}
+
+func TestCompactVersions(t *testing.T) {
+	sd := testPiggybackSpan()
+
+	// Version 1 encodings, written before versioning.
+	legacy := map[string]string{
+		"json": `{"t":"0102030405060708090a0b0c0d0e0f10","s":"0102030405060708","n":"/hello","f":"1600000000123456","d":"1500"}`,
+	}
+	var w binaryWriter
+	w.byte(binaryCompact)
+	w.spanHeader(sd, false)
+	legacy["binary"] = base64.StdEncoding.EncodeToString(w.buf)
+	legacy["proto"] = base64.StdEncoding.EncodeToString(appendProtoSpanHeader(nil, sd))
+	for name, v := range legacy {
+		got, err := testCodecs[name].Decode(v)
+		if err != nil {
+			t.Errorf("%s: Decode() of version 1 error: %v", name, err)
+			continue
+		}
+		if got.Name != sd.Name || got.SpanID != sd.SpanID {
+			t.Errorf("%s: Decode() of version 1 = %+v", name, got)
+		}
+	}
+
+	// Encodings of a later version.
+	future := map[string]string{
+		"json": `{"t":"0102030405060708090a0b0c0d0e0f10","s":"0102030405060708","n":"/hello","f":"1","d":"1","ver":3}`,
+	}
+	w = binaryWriter{}
+	w.byte(binaryVersioned)
+	w.uvarint(CompactSchemaVersion + 1)
+	w.spanHeader(sd, false)
+	future["binary"] = base64.StdEncoding.EncodeToString(w.buf)
+	b := appendProtoSpanHeader(nil, sd)
+	b = protowire.AppendTag(b, 21, protowire.VarintType)
+	b = protowire.AppendVarint(b, CompactSchemaVersion+1)
+	future["proto"] = base64.StdEncoding.EncodeToString(b)
+	for name, v := range future {
+		if _, err := testCodecs[name].Decode(v); !errors.Is(err, ErrUnsupportedCompactVersion) {
+			t.Errorf("%s: Decode() of a later version error = %v; want ErrUnsupportedCompactVersion", name, err)
+		}
+	}
+}
+
+type schemaExporter struct {
+	piggybackExporter
+	schema CompactSchema
+}
+
+func (e *schemaExporter) CompactSchema() CompactSchema { return e.schema }
+
+func TestEndAndAggregateUsesExporterSchema(t *testing.T) {
+	old := config.Load()
+	defer config.Store(old)
+	ApplyConfig(Config{MaxAttributesPerSpan: DefaultMaxAttributesPerSpan, PiggybackCodec: JSONCodec()})
+	e := &schemaExporter{schema: CompactSchema{Status: true, Attributes: []string{"k"}}}
+	RegisterExporter(e)
+	defer UnregisterExporter(e)
+
+	_, span := StartSpan(context.Background(), "server", WithSampler(AlwaysSample()))
+	span.AddAttributes(StringAttribute("k", "v"), StringAttribute("other", "v"))
+	span.SetStatus(Status{Code: StatusCodeNotFound})
+	w := httptest.NewRecorder()
+	span.EndAndAggregate(w, nil)
+
+	values := w.Header()[AggregationHeader]
+	if len(values) != 1 {
+		t.Fatalf("got %d aggregated spans; want 1", len(values))
+	}
+	got, err := JSONCodec().Decode(values[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Code != StatusCodeNotFound {
+		t.Errorf("status = %v; want NotFound", got.Status)
+	}
+	if want := map[string]interface{}{"k": "v"}; !reflect.DeepEqual(got.Attributes, want) {
+		t.Errorf("attributes = %v; want %v", got.Attributes, want)
+	}
+}
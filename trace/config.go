@@ -16,6 +16,7 @@ package trace
 
 import (
 	"sync"
+	"time"
 
 	"github.com/Yangfisher1/opencensus-go/trace/internal"
 )
@@ -39,6 +40,39 @@ type Config struct {
 
 	// MaxLinksPerSpan is max number of links per span
 	MaxLinksPerSpan int
+
+	// PiggybackCodec encodes the spans carried back to the caller in the
+	// AggregationHeader. It defaults to JSONCodec and must match the codec
+	// used by every other process in the call chain.
+	PiggybackCodec PiggybackCodec
+
+	// MaxAggregationHeaderBytes caps the size of the AggregationHeader values
+	// a server adds to its response. When exceeded, sibling spans sharing a
+	// name are merged and then the least important spans are dropped.
+	// Zero means no limit.
+	MaxAggregationHeaderBytes int
+
+	// LatencyBaselineWindow enables per span name latency baselines, queried
+	// with LatencyQuantile, covering finished spans of the last one to two
+	// windows. Zero leaves baselines disabled.
+	LatencyBaselineWindow time.Duration
+
+	// SummarizeSiblings collapses groups of at least this many sibling
+	// spans sharing a name into a single summary record before they are
+	// piggybacked to the caller, bounding the entries fan-out produces.
+	// Summaries carry the count, duration statistics, error count and
+	// exemplar span IDs in the Summary attributes. Zero disables it.
+	SummarizeSiblings int
+
+	// ErrorHandler is called with errors that occur while ending spans,
+	// such as spans that cannot be encoded for the AggregationHeader. Such
+	// spans are exported directly instead. Errors are discarded if it is
+	// nil.
+	ErrorHandler func(error)
+
+	// Redactor scrubs sensitive data from spans as they end, and from the
+	// running spans reported to zpages. Nil leaves spans unchanged.
+	Redactor *Redactor
 }
 
 var configWriteMu sync.Mutex
@@ -82,5 +116,23 @@ func ApplyConfig(cfg Config) {
 	if cfg.MaxLinksPerSpan > 0 {
 		c.MaxLinksPerSpan = cfg.MaxLinksPerSpan
 	}
+	if cfg.PiggybackCodec != nil {
+		c.PiggybackCodec = cfg.PiggybackCodec
+	}
+	if cfg.MaxAggregationHeaderBytes > 0 {
+		c.MaxAggregationHeaderBytes = cfg.MaxAggregationHeaderBytes
+	}
+	if cfg.LatencyBaselineWindow > 0 {
+		c.LatencyBaselineWindow = cfg.LatencyBaselineWindow
+	}
+	if cfg.SummarizeSiblings > 0 {
+		c.SummarizeSiblings = cfg.SummarizeSiblings
+	}
+	if cfg.ErrorHandler != nil {
+		c.ErrorHandler = cfg.ErrorHandler
+	}
+	if cfg.Redactor != nil {
+		c.Redactor = cfg.Redactor
+	}
 	config.Store(&c)
 }
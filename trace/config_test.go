@@ -24,6 +24,7 @@ func TestApplyConfig(t *testing.T) {
 	defaultCfg := Config{
 		DefaultSampler:             cfg.DefaultSampler,
 		IDGenerator:                cfg.IDGenerator,
+		PiggybackCodec:             cfg.PiggybackCodec,
 		MaxAttributesPerSpan:       DefaultMaxAttributesPerSpan,
 		MaxAnnotationEventsPerSpan: DefaultMaxAnnotationEventsPerSpan,
 		MaxMessageEventsPerSpan:    DefaultMaxMessageEventsPerSpan,
@@ -55,6 +56,7 @@ func TestApplyConfig(t *testing.T) {
 			wantCfg: Config{
 				DefaultSampler:             cfg.DefaultSampler,
 				IDGenerator:                cfg.IDGenerator,
+				PiggybackCodec:             cfg.PiggybackCodec,
 				MaxAttributesPerSpan:       1,
 				MaxAnnotationEventsPerSpan: 2,
 				MaxMessageEventsPerSpan:    3,
@@ -72,6 +74,7 @@ func TestApplyConfig(t *testing.T) {
 			wantCfg: Config{
 				DefaultSampler:             cfg.DefaultSampler,
 				IDGenerator:                cfg.IDGenerator,
+				PiggybackCodec:             cfg.PiggybackCodec,
 				MaxAttributesPerSpan:       1,
 				MaxAnnotationEventsPerSpan: 3,
 				MaxMessageEventsPerSpan:    3,
@@ -104,6 +107,9 @@ func TestApplyConfig(t *testing.T) {
 		if got, want := gotCfg.MaxMessageEventsPerSpan, wantCfg.MaxMessageEventsPerSpan; got != want {
 			t.Fatalf("testId = %d, testName = %s: config.MaxMessageEventsPerSpan = %#v; want %#v", i, tt.name, got, want)
 		}
+		if got, want := gotCfg.PiggybackCodec, wantCfg.PiggybackCodec; got != want {
+			t.Fatalf("testId = %d, testName = %s: config.PiggybackCodec = %#v; want %#v", i, tt.name, got, want)
+		}
 
 	}
 }
@@ -15,7 +15,9 @@
 package trace
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,11 +32,33 @@ import (
 // The SpanData should not be modified, but a pointer to it can be kept.
 type Exporter interface {
 	ExportSpan(s *SpanData)
-	// Filter whether span is valid or not
+}
+
+// AggregatingExporter is an Exporter that takes part in serverless span
+// aggregation. Spans ended with Span.EndAndAggregate or Span.EndAtClient are
+// classified by FilterSpan and either piggybacked on the response, handed
+// to AggregateSpanFromHeader at an aggregation point, or exported
+// immediately. Exporters that do not implement it receive such spans
+// through ExportSpan right away.
+type AggregatingExporter interface {
+	Exporter
+
+	// FilterSpan decides how a finished span is handled.
 	FilterSpan(s *SpanData) ErrorType
-	AggregateSpanFromHeader(w http.Header)
+
+	// AggregateSpanFromHeader receives the spans collected at an
+	// aggregation point, encoded in the AggregationHeader values of h.
+	AggregateSpanFromHeader(h http.Header)
 }
 
+// AggregationHeader is the header, sent as an HTTP trailer, that carries
+// encoded serverless spans back to the caller.
+const AggregationHeader = "Agg"
+
+// AggregationAcceptHeader is the request header by which a caller
+// advertises that it consumes the AggregationHeader trailer.
+const AggregationAcceptHeader = "Agg-Accept"
+
 type exportersMap map[Exporter]struct{}
 
 var (
@@ -74,6 +98,51 @@ func UnregisterExporter(e Exporter) {
 	exporterMu.Unlock()
 }
 
+// ExportAggregated decodes AggregationHeader values with
+// Config.PiggybackCodec and exports the spans to all registered exporters.
+// It is used when aggregated spans cannot be sent back to the caller.
+// Dropped-span entries are skipped, as are values that fail to decode; the
+// first decoding error is returned.
+func ExportAggregated(values []string) error {
+	exp, _ := exporters.Load().(exportersMap)
+	codec := config.Load().(*Config).PiggybackCodec
+	var firstErr error
+	for _, v := range values {
+		if _, ok := ParseDroppedEntry(v); ok {
+			continue
+		}
+		sd, err := DecodeAggregated(codec, v)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for e := range exp {
+			e.ExportSpan(sd)
+		}
+	}
+	return firstErr
+}
+
+// ReportError passes err to Config.ErrorHandler, if set. Integrations use it
+// to report errors that occur while handling spans on behalf of this
+// package, such as aggregated spans that could not be exported.
+func ReportError(err error) {
+	if h := config.Load().(*Config).ErrorHandler; h != nil {
+		h(err)
+	}
+}
+
+// handleEncodeError reports that sd could not be encoded for the
+// AggregationHeader on behalf of e, and exports it to e directly so that
+// the span is not lost.
+func handleEncodeError(e AggregatingExporter, sd *SpanData, err error) {
+	ReportError(fmt.Errorf("trace: encoding span %q for %T: %w", sd.Name, e, err))
+	recordExporterFailure(e)
+	e.ExportSpan(sd)
+}
+
 // SpanData contains all the information collected by a Span.
 type SpanData struct {
 	SpanContext
@@ -101,7 +170,7 @@ type SpanData struct {
 }
 
 // ServerlessSpanData contains all the necessary information for a normal serverless span.
-// TODO: simplify this struct later maybe
+// It is the compact form of a span encoded by JSONCodec; see CompactSchema.
 type ServerlessSpanData struct {
 	TraceID      string `json:"t,omitempty"`
 	SpanID       string `json:"s,omitempty"`
@@ -109,8 +178,30 @@ type ServerlessSpanData struct {
 	Name         string `json:"n,omitempty"`
 	StartTime    string `json:"f"`
 	Duration     string `json:"d"`
+
+	// Invocation metadata of serverless server spans; see
+	// ColdStartAttribute and the related attributes.
+	ColdStart       bool   `json:"c,omitempty"`
+	InitDuration    string `json:"i,omitempty"`
+	InvocationCount string `json:"v,omitempty"`
+	FunctionName    string `json:"fn,omitempty"`
+	FunctionVersion string `json:"fv,omitempty"`
+
+	// Version is the CompactSchemaVersion the span was written with; it is
+	// absent in version 1. The fields below were added in version 2 and
+	// are set as selected by the CompactSchema.
+	Version       int                    `json:"ver,omitempty"`
+	StatusCode    int32                  `json:"sc,omitempty"`
+	StatusMessage string                 `json:"sm,omitempty"`
+	Kind          int                    `json:"k,omitempty"`
+	Attributes    map[string]interface{} `json:"a,omitempty"`
+
+	// RemoteParent is set for spans started from a remote parent, such as
+	// server spans. The aggregator corrects clock skew at these hops.
+	RemoteParent bool `json:"r,omitempty"`
 }
 
+// ErrorType classifies a finished span for an AggregatingExporter.
 type ErrorType int
 
 const (
@@ -120,3 +211,19 @@ const (
 	PerformanceDown
 	Error
 )
+
+func (t ErrorType) String() string {
+	switch t {
+	case OK:
+		return "OK"
+	case Aggregate:
+		return "Aggregate"
+	case UserSpec:
+		return "UserSpec"
+	case PerformanceDown:
+		return "PerformanceDown"
+	case Error:
+		return "Error"
+	}
+	return "ErrorType(" + strconv.Itoa(int(t)) + ")"
+}
@@ -0,0 +1,113 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type plainExporter struct {
+	spans []*SpanData
+}
+
+func (e *plainExporter) ExportSpan(sd *SpanData) {
+	e.spans = append(e.spans, sd)
+}
+
+type piggybackExporter struct {
+	plainExporter
+}
+
+func (e *piggybackExporter) FilterSpan(sd *SpanData) ErrorType {
+	return OK
+}
+
+func (e *piggybackExporter) AggregateSpanFromHeader(h http.Header) {}
+
+func TestEndWithPlainAndAggregatingExporters(t *testing.T) {
+	plain := &plainExporter{}
+	piggyback := &piggybackExporter{}
+	RegisterExporter(plain)
+	RegisterExporter(piggyback)
+	defer UnregisterExporter(plain)
+	defer UnregisterExporter(piggyback)
+
+	_, server := StartSpan(context.Background(), "server", WithSampler(AlwaysSample()))
+	w := httptest.NewRecorder()
+	server.EndAndAggregate(w, nil)
+
+	_, client := StartSpan(context.Background(), "client", WithSampler(AlwaysSample()))
+	trailer := make(http.Header)
+	client.EndAtClient(&trailer)
+
+	if len(plain.spans) != 2 {
+		t.Errorf("plain exporter got %d spans; want 2", len(plain.spans))
+	}
+	if len(piggyback.spans) != 0 {
+		t.Errorf("aggregating exporter got %d exported spans; want 0", len(piggyback.spans))
+	}
+	if got := len(w.Header()[AggregationHeader]); got != 1 {
+		t.Errorf("server response has %d aggregated spans; want 1", got)
+	}
+	if got := len(trailer[AggregationHeader]); got != 1 {
+		t.Errorf("client trailer has %d aggregated spans; want 1", got)
+	}
+}
+
+type failingCodec struct {
+	PiggybackCodec
+}
+
+func (failingCodec) EncodeCompact(*SpanData, CompactSchema) (string, error) {
+	return "", errors.New("encoding failed")
+}
+
+func TestEndReportsEncodeErrors(t *testing.T) {
+	old := config.Load()
+	defer config.Store(old)
+	var errs []error
+	ApplyConfig(Config{
+		PiggybackCodec: failingCodec{JSONCodec()},
+		ErrorHandler:   func(err error) { errs = append(errs, err) },
+	})
+	first, second := &piggybackExporter{}, &piggybackExporter{}
+	RegisterExporter(first)
+	RegisterExporter(second)
+	defer UnregisterExporter(first)
+	defer UnregisterExporter(second)
+
+	_, server := StartSpan(context.Background(), "server", WithSampler(AlwaysSample()))
+	w := httptest.NewRecorder()
+	server.EndAndAggregate(w, nil)
+
+	_, client := StartSpan(context.Background(), "client", WithSampler(AlwaysSample()))
+	client.EndAtClient(&http.Header{})
+
+	if len(errs) != 4 {
+		t.Errorf("ErrorHandler got %d errors; want 4: %v", len(errs), errs)
+	}
+	for _, e := range []*piggybackExporter{first, second} {
+		if len(e.spans) != 2 {
+			t.Errorf("exporter got %d spans exported directly; want 2", len(e.spans))
+		}
+	}
+	if got := len(w.Header()[AggregationHeader]); got != 0 {
+		t.Errorf("server response has %d aggregated spans; want 0", got)
+	}
+}
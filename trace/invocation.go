@@ -0,0 +1,111 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "strconv"
+
+// Attributes describing the invocation of a serverless function, recorded
+// on server spans by ochttp.Handler. Compact encodings carry them along
+// with the other ServerlessSpanData fields, so that aggregation points can
+// tell cold starts from warm ones.
+const (
+	// ColdStartAttribute is true on the first invocation of an instance.
+	ColdStartAttribute = "faas.coldstart"
+	// InitDurationAttribute is the time, in microseconds, an instance took
+	// from starting to serving its first invocation. It is only set on
+	// cold starts.
+	InitDurationAttribute = "faas.init_duration_us"
+	// InvocationCountAttribute numbers the invocations of an instance,
+	// starting at 1.
+	InvocationCountAttribute = "faas.invocation_count"
+	// FunctionNameAttribute and FunctionVersionAttribute identify the
+	// invoked function.
+	FunctionNameAttribute    = "faas.name"
+	FunctionVersionAttribute = "faas.version"
+)
+
+// invocationAttributes returns the invocation attributes of sd, or nil if
+// it has none.
+func invocationAttributes(sd *SpanData) map[string]interface{} {
+	var attrs map[string]interface{}
+	add := func(key string, v interface{}) {
+		if attrs == nil {
+			attrs = make(map[string]interface{})
+		}
+		attrs[key] = v
+	}
+	if v, ok := sd.Attributes[ColdStartAttribute].(bool); ok && v {
+		add(ColdStartAttribute, v)
+	}
+	for _, key := range []string{InitDurationAttribute, InvocationCountAttribute} {
+		if v, ok := sd.Attributes[key].(int64); ok {
+			add(key, v)
+		}
+	}
+	for _, key := range []string{FunctionNameAttribute, FunctionVersionAttribute} {
+		if v, ok := sd.Attributes[key].(string); ok && v != "" {
+			add(key, v)
+		}
+	}
+	return attrs
+}
+
+func isInvocationAttribute(key string) bool {
+	switch key {
+	case ColdStartAttribute, InitDurationAttribute, InvocationCountAttribute,
+		FunctionNameAttribute, FunctionVersionAttribute:
+		return true
+	}
+	return false
+}
+
+// setInvocationFields copies the invocation attributes of sd into ssd.
+func setInvocationFields(ssd *ServerlessSpanData, sd *SpanData) {
+	attrs := invocationAttributes(sd)
+	ssd.ColdStart, _ = attrs[ColdStartAttribute].(bool)
+	if v, ok := attrs[InitDurationAttribute].(int64); ok {
+		ssd.InitDuration = strconv.FormatInt(v, 10)
+	}
+	if v, ok := attrs[InvocationCountAttribute].(int64); ok {
+		ssd.InvocationCount = strconv.FormatInt(v, 10)
+	}
+	ssd.FunctionName, _ = attrs[FunctionNameAttribute].(string)
+	ssd.FunctionVersion, _ = attrs[FunctionVersionAttribute].(string)
+}
+
+// invocationAttributesFromServerless is the inverse of setInvocationFields.
+func invocationAttributesFromServerless(ssd *ServerlessSpanData) (map[string]interface{}, error) {
+	var sd SpanData
+	sd.Attributes = make(map[string]interface{})
+	if ssd.ColdStart {
+		sd.Attributes[ColdStartAttribute] = true
+	}
+	for key, s := range map[string]string{
+		InitDurationAttribute:    ssd.InitDuration,
+		InvocationCountAttribute: ssd.InvocationCount,
+	} {
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sd.Attributes[key] = v
+	}
+	sd.Attributes[FunctionNameAttribute] = ssd.FunctionName
+	sd.Attributes[FunctionVersionAttribute] = ssd.FunctionVersion
+	return invocationAttributes(&sd), nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PiggybackCodec encodes finished spans into the values of the
+// AggregationHeader and decodes them again at the aggregation point.
+//
+// Every process in a call chain must use the same codec; it is selected
+// with Config.PiggybackCodec.
+type PiggybackCodec interface {
+	// EncodeServerless encodes the compact form of a span with the zero
+	// CompactSchema.
+	EncodeServerless(sd *SpanData) (string, error)
+
+	// EncodeCompact encodes the compact form of a span, in version
+	// CompactSchemaVersion of the schema, with the optional fields
+	// selected by schema.
+	EncodeCompact(sd *SpanData, schema CompactSchema) (string, error)
+
+	// EncodeSpanData encodes the complete span. It is used for spans
+	// classified as PerformanceDown.
+	EncodeSpanData(sd *SpanData) (string, error)
+
+	// Decode decodes a value produced by any of the encode methods.
+	// Values in the compact form only populate the trace and span IDs, the
+	// parent span ID, the name, the start and end times, and the optional
+	// fields they carry. Compact values of an unknown schema version are
+	// rejected with ErrUnsupportedCompactVersion.
+	Decode(value string) (*SpanData, error)
+
+	// Name identifies the codec and the version of its format, as in
+	// "json/1". Callers advertise the names of the codecs they accept in
+	// the AggregationAcceptHeader.
+	Name() string
+}
+
+// JSONCodec returns a PiggybackCodec that encodes spans as JSON. Compact
+// spans are encoded as ServerlessSpanData.
+func JSONCodec() PiggybackCodec {
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json/1" }
+
+func (c jsonCodec) EncodeServerless(sd *SpanData) (string, error) {
+	return c.EncodeCompact(sd, CompactSchema{})
+}
+
+func (jsonCodec) EncodeCompact(sd *SpanData, schema CompactSchema) (string, error) {
+	b, err := json.Marshal(makeServerlessSpanData(schema.view(sd)))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (jsonCodec) EncodeSpanData(sd *SpanData) (string, error) {
+	b, err := json.Marshal(sd)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (jsonCodec) Decode(value string) (*SpanData, error) {
+	// Compact entries always carry the trace ID under the "t" key, which a
+	// full SpanData never has.
+	var probe struct {
+		TraceID string `json:"t"`
+	}
+	if err := json.Unmarshal([]byte(value), &probe); err != nil {
+		return nil, err
+	}
+	if probe.TraceID == "" {
+		var sd SpanData
+		if err := json.Unmarshal([]byte(value), &sd); err != nil {
+			return nil, err
+		}
+		return &sd, nil
+	}
+	var ssd ServerlessSpanData
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.UseNumber() // keep integer attributes apart from floats
+	if err := dec.Decode(&ssd); err != nil {
+		return nil, err
+	}
+	return spanDataFromServerless(&ssd)
+}
+
+// spanDataFromServerless converts a ServerlessSpanData back into the subset
+// of SpanData it was made from.
+func spanDataFromServerless(ssd *ServerlessSpanData) (*SpanData, error) {
+	version := ssd.Version
+	if version == 0 {
+		version = 1 // written before versioning
+	}
+	if err := checkCompactVersion(version); err != nil {
+		return nil, err
+	}
+	var sd SpanData
+	if err := decodeHexID(sd.TraceID[:], ssd.TraceID); err != nil {
+		return nil, fmt.Errorf("trace: invalid trace ID %q: %v", ssd.TraceID, err)
+	}
+	if err := decodeHexID(sd.SpanID[:], ssd.SpanID); err != nil {
+		return nil, fmt.Errorf("trace: invalid span ID %q: %v", ssd.SpanID, err)
+	}
+	if ssd.ParentSpanID != "" {
+		if err := decodeHexID(sd.ParentSpanID[:], ssd.ParentSpanID); err != nil {
+			return nil, fmt.Errorf("trace: invalid parent span ID %q: %v", ssd.ParentSpanID, err)
+		}
+	}
+	start, err := strconv.ParseInt(ssd.StartTime, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("trace: invalid start time %q: %v", ssd.StartTime, err)
+	}
+	duration, err := strconv.ParseInt(ssd.Duration, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("trace: invalid duration %q: %v", ssd.Duration, err)
+	}
+	if sd.Attributes, err = invocationAttributesFromServerless(ssd); err != nil {
+		return nil, fmt.Errorf("trace: invalid invocation fields: %v", err)
+	}
+	for k, v := range ssd.Attributes {
+		switch v := v.(type) {
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				ssd.Attributes[k] = i
+			} else if f, err := v.Float64(); err == nil {
+				ssd.Attributes[k] = f
+			} else {
+				return nil, fmt.Errorf("trace: invalid attribute %q: %v", k, err)
+			}
+		case string, bool, float64:
+		default:
+			return nil, fmt.Errorf("trace: invalid attribute %q of type %T", k, v)
+		}
+		if sd.Attributes == nil {
+			sd.Attributes = make(map[string]interface{})
+		}
+		sd.Attributes[k] = ssd.Attributes[k]
+	}
+	sd.Status = Status{Code: ssd.StatusCode, Message: ssd.StatusMessage}
+	sd.SpanKind = ssd.Kind
+	sd.HasRemoteParent = ssd.RemoteParent
+	sd.Name = ssd.Name
+	sd.StartTime = time.UnixMicro(start)
+	sd.EndTime = sd.StartTime.Add(time.Duration(duration) * time.Microsecond)
+	return &sd, nil
+}
+
+func decodeHexID(dst []byte, s string) error {
+	if hex.DecodedLen(len(s)) != len(dst) {
+		return fmt.Errorf("want %d bytes", len(dst))
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
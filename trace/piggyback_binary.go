@@ -0,0 +1,394 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Leading byte of a binary encoded span. binaryCompact is the unversioned
+// layout of schema version 1; binaryVersioned is followed by the schema
+// version of a compact span.
+const (
+	binaryCompact   byte = 1
+	binaryFull      byte = 2
+	binaryVersioned byte = 3
+)
+
+// Flags following the IDs of a binary encoded span.
+const (
+	binaryHasParent byte = 1 << iota
+	binaryHasRemoteParent
+)
+
+// Type tags of binary encoded attribute values.
+const (
+	binaryString byte = iota
+	binaryBool
+	binaryInt64
+	binaryFloat64
+)
+
+var errBinaryTruncated = errors.New("trace: truncated binary span")
+
+// BinaryCodec returns a PiggybackCodec with a compact binary layout: raw
+// 16 and 8 byte IDs followed by the name and varint encoded times in
+// microseconds, all base64 encoded to be carried in a header.
+func BinaryCodec() PiggybackCodec {
+	return binaryCodec{}
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary/1" }
+
+func (c binaryCodec) EncodeServerless(sd *SpanData) (string, error) {
+	return c.EncodeCompact(sd, CompactSchema{})
+}
+
+func (binaryCodec) EncodeCompact(sd *SpanData, schema CompactSchema) (string, error) {
+	v := schema.view(sd)
+	var w binaryWriter
+	w.byte(binaryVersioned)
+	w.uvarint(CompactSchemaVersion)
+	w.spanHeader(v, v.HasRemoteParent)
+	w.varint(int64(v.Status.Code))
+	w.string(v.Status.Message)
+	w.varint(int64(v.SpanKind))
+	if err := w.attributes(v.Attributes); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(w.buf), nil
+}
+
+func (binaryCodec) EncodeSpanData(sd *SpanData) (string, error) {
+	var w binaryWriter
+	w.byte(binaryFull)
+	w.spanHeader(sd, sd.HasRemoteParent)
+	w.uvarint(uint64(sd.TraceOptions))
+	w.varint(int64(sd.SpanKind))
+	w.varint(int64(sd.Status.Code))
+	w.string(sd.Status.Message)
+	if err := w.attributes(sd.Attributes); err != nil {
+		return "", err
+	}
+	w.uvarint(uint64(len(sd.Annotations)))
+	for _, a := range sd.Annotations {
+		w.time(a.Time)
+		w.string(a.Message)
+		if err := w.attributes(a.Attributes); err != nil {
+			return "", err
+		}
+	}
+	w.uvarint(uint64(len(sd.MessageEvents)))
+	for _, e := range sd.MessageEvents {
+		w.time(e.Time)
+		w.varint(int64(e.EventType))
+		w.varint(e.MessageID)
+		w.varint(e.UncompressedByteSize)
+		w.varint(e.CompressedByteSize)
+	}
+	w.uvarint(uint64(len(sd.Links)))
+	for _, l := range sd.Links {
+		w.raw(l.TraceID[:])
+		w.raw(l.SpanID[:])
+		w.varint(int64(l.Type))
+		if err := w.attributes(l.Attributes); err != nil {
+			return "", err
+		}
+	}
+	w.varint(int64(sd.DroppedAttributeCount))
+	w.varint(int64(sd.DroppedAnnotationCount))
+	w.varint(int64(sd.DroppedMessageEventCount))
+	w.varint(int64(sd.DroppedLinkCount))
+	w.varint(int64(sd.ChildSpanCount))
+	return base64.StdEncoding.EncodeToString(w.buf), nil
+}
+
+func (binaryCodec) Decode(value string) (*SpanData, error) {
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	r := binaryReader{buf: b}
+	format := r.byte()
+	if r.err == nil && format != binaryCompact && format != binaryFull && format != binaryVersioned {
+		return nil, fmt.Errorf("trace: unknown binary span format %d", format)
+	}
+	if format == binaryVersioned {
+		v := r.uvarint()
+		if r.err == nil && (v < 1 || v > CompactSchemaVersion) {
+			return nil, fmt.Errorf("%w %d", ErrUnsupportedCompactVersion, v)
+		}
+	}
+	var sd SpanData
+	r.spanHeader(&sd)
+	switch format {
+	case binaryCompact:
+		if len(r.buf) > 0 {
+			// Invocation attributes, absent from older encodings.
+			sd.Attributes = r.attributes()
+		}
+	case binaryVersioned:
+		sd.Status.Code = int32(r.varint())
+		sd.Status.Message = r.string()
+		sd.SpanKind = int(r.varint())
+		sd.Attributes = r.attributes()
+	case binaryFull:
+		sd.TraceOptions = TraceOptions(r.uvarint())
+		sd.SpanKind = int(r.varint())
+		sd.Status.Code = int32(r.varint())
+		sd.Status.Message = r.string()
+		sd.Attributes = r.attributes()
+		if n := r.count(); n > 0 {
+			sd.Annotations = make([]Annotation, n)
+			for i := range sd.Annotations {
+				sd.Annotations[i].Time = r.time()
+				sd.Annotations[i].Message = r.string()
+				sd.Annotations[i].Attributes = r.attributes()
+			}
+		}
+		if n := r.count(); n > 0 {
+			sd.MessageEvents = make([]MessageEvent, n)
+			for i := range sd.MessageEvents {
+				e := &sd.MessageEvents[i]
+				e.Time = r.time()
+				e.EventType = MessageEventType(r.varint())
+				e.MessageID = r.varint()
+				e.UncompressedByteSize = r.varint()
+				e.CompressedByteSize = r.varint()
+			}
+		}
+		if n := r.count(); n > 0 {
+			sd.Links = make([]Link, n)
+			for i := range sd.Links {
+				l := &sd.Links[i]
+				r.raw(l.TraceID[:])
+				r.raw(l.SpanID[:])
+				l.Type = LinkType(r.varint())
+				l.Attributes = r.attributes()
+			}
+		}
+		sd.DroppedAttributeCount = int(r.varint())
+		sd.DroppedAnnotationCount = int(r.varint())
+		sd.DroppedMessageEventCount = int(r.varint())
+		sd.DroppedLinkCount = int(r.varint())
+		sd.ChildSpanCount = int(r.varint())
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &sd, nil
+}
+
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *binaryWriter) raw(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func (w *binaryWriter) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, tmp[:binary.PutVarint(tmp[:], v)]...)
+}
+
+func (w *binaryWriter) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *binaryWriter) time(t time.Time) {
+	w.varint(t.UnixMicro())
+}
+
+// spanHeader writes the fields shared by the compact and full layouts.
+func (w *binaryWriter) spanHeader(sd *SpanData, remoteParent bool) {
+	w.raw(sd.TraceID[:])
+	w.raw(sd.SpanID[:])
+	var flags byte
+	if sd.ParentSpanID != (SpanID{}) {
+		flags |= binaryHasParent
+	}
+	if remoteParent {
+		flags |= binaryHasRemoteParent
+	}
+	w.byte(flags)
+	if flags&binaryHasParent != 0 {
+		w.raw(sd.ParentSpanID[:])
+	}
+	w.string(sd.Name)
+	w.time(sd.StartTime)
+	w.uvarint(uint64(sd.EndTime.UnixMicro() - sd.StartTime.UnixMicro()))
+}
+
+func (w *binaryWriter) attributes(attrs map[string]interface{}) error {
+	w.uvarint(uint64(len(attrs)))
+	for k, v := range attrs {
+		w.string(k)
+		switch v := v.(type) {
+		case string:
+			w.byte(binaryString)
+			w.string(v)
+		case bool:
+			w.byte(binaryBool)
+			if v {
+				w.byte(1)
+			} else {
+				w.byte(0)
+			}
+		case int64:
+			w.byte(binaryInt64)
+			w.varint(v)
+		case float64:
+			w.byte(binaryFloat64)
+			var tmp [8]byte
+			binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+			w.raw(tmp[:])
+		default:
+			return fmt.Errorf("trace: unsupported attribute type %T for key %q", v, k)
+		}
+	}
+	return nil
+}
+
+// binaryReader decodes the layout written by binaryWriter. The first error
+// is kept in err and turns every subsequent read into a no-op.
+type binaryReader struct {
+	buf []byte
+	err error
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.buf = nil
+}
+
+func (r *binaryReader) byte() byte {
+	if len(r.buf) < 1 {
+		r.fail(errBinaryTruncated)
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *binaryReader) raw(dst []byte) {
+	if len(r.buf) < len(dst) {
+		r.fail(errBinaryTruncated)
+		return
+	}
+	copy(dst, r.buf)
+	r.buf = r.buf[len(dst):]
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.fail(errBinaryTruncated)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.fail(errBinaryTruncated)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// count reads a collection length, rejecting lengths that cannot possibly
+// fit in the remaining input.
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.buf)) {
+		r.fail(errBinaryTruncated)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) string() string {
+	n := r.count()
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+func (r *binaryReader) time() time.Time {
+	return time.UnixMicro(r.varint())
+}
+
+func (r *binaryReader) spanHeader(sd *SpanData) {
+	r.raw(sd.TraceID[:])
+	r.raw(sd.SpanID[:])
+	flags := r.byte()
+	if flags&binaryHasParent != 0 {
+		r.raw(sd.ParentSpanID[:])
+	}
+	sd.HasRemoteParent = flags&binaryHasRemoteParent != 0
+	sd.Name = r.string()
+	sd.StartTime = r.time()
+	sd.EndTime = sd.StartTime.Add(time.Duration(r.uvarint()) * time.Microsecond)
+}
+
+func (r *binaryReader) attributes() map[string]interface{} {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+	attrs := make(map[string]interface{}, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		k := r.string()
+		switch t := r.byte(); t {
+		case binaryString:
+			attrs[k] = r.string()
+		case binaryBool:
+			attrs[k] = r.byte() != 0
+		case binaryInt64:
+			attrs[k] = r.varint()
+		case binaryFloat64:
+			var tmp [8]byte
+			r.raw(tmp[:])
+			attrs[k] = math.Float64frombits(binary.LittleEndian.Uint64(tmp[:]))
+		default:
+			r.fail(fmt.Errorf("trace: unknown attribute type %d", t))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,417 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ProtoCodec returns a PiggybackCodec that encodes spans as base64 encoded
+// protocol buffers with the following schema:
+//
+//	message Span {
+//	  bytes trace_id = 1;
+//	  bytes span_id = 2;
+//	  bytes parent_span_id = 3;
+//	  string name = 4;
+//	  sint64 start_time_unix_micro = 5;
+//	  uint64 duration_micro = 6;
+//	  // The fields below are only set for complete spans, except for
+//	  // kind, status and attributes, which compact spans carry as selected
+//	  // by their CompactSchema, and has_remote_parent.
+//	  uint32 trace_options = 7;
+//	  int32 kind = 8;
+//	  int32 status_code = 9;
+//	  string status_message = 10;
+//	  repeated Attribute attributes = 11;
+//	  repeated Annotation annotations = 12;
+//	  repeated MessageEvent message_events = 13;
+//	  repeated Link links = 14;
+//	  bool has_remote_parent = 15;
+//	  int32 dropped_attribute_count = 16;
+//	  int32 dropped_annotation_count = 17;
+//	  int32 dropped_message_event_count = 18;
+//	  int32 dropped_link_count = 19;
+//	  int32 child_span_count = 20;
+//	  // The CompactSchemaVersion of a compact span, unset in version 1.
+//	  uint32 compact_version = 21;
+//	}
+//
+//	message Attribute {
+//	  string key = 1;
+//	  oneof value {
+//	    string string_value = 2;
+//	    bool bool_value = 3;
+//	    int64 int_value = 4;
+//	    double double_value = 5;
+//	  }
+//	}
+//
+//	message Annotation {
+//	  sint64 time_unix_micro = 1;
+//	  string message = 2;
+//	  repeated Attribute attributes = 3;
+//	}
+//
+//	message MessageEvent {
+//	  sint64 time_unix_micro = 1;
+//	  int32 type = 2;
+//	  int64 id = 3;
+//	  int64 uncompressed_size = 4;
+//	  int64 compressed_size = 5;
+//	}
+//
+//	message Link {
+//	  bytes trace_id = 1;
+//	  bytes span_id = 2;
+//	  int32 type = 3;
+//	  repeated Attribute attributes = 4;
+//	}
+func ProtoCodec() PiggybackCodec {
+	return protoCodec{}
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return "proto/1" }
+
+func (c protoCodec) EncodeServerless(sd *SpanData) (string, error) {
+	return c.EncodeCompact(sd, CompactSchema{})
+}
+
+func (protoCodec) EncodeCompact(sd *SpanData, schema CompactSchema) (string, error) {
+	v := schema.view(sd)
+	b := appendProtoSpanHeader(nil, v)
+	b = appendProtoVarint(b, 8, uint64(v.SpanKind))
+	b = appendProtoVarint(b, 9, uint64(v.Status.Code))
+	b = appendProtoString(b, 10, v.Status.Message)
+	b, err := appendProtoAttributes(b, 11, v.Attributes)
+	if err != nil {
+		return "", err
+	}
+	if v.HasRemoteParent {
+		b = appendProtoVarint(b, 15, 1)
+	}
+	b = appendProtoVarint(b, 21, CompactSchemaVersion)
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (protoCodec) EncodeSpanData(sd *SpanData) (string, error) {
+	b := appendProtoSpanHeader(nil, sd)
+	b = appendProtoVarint(b, 7, uint64(sd.TraceOptions))
+	b = appendProtoVarint(b, 8, uint64(sd.SpanKind))
+	b = appendProtoVarint(b, 9, uint64(sd.Status.Code))
+	b = appendProtoString(b, 10, sd.Status.Message)
+	var err error
+	if b, err = appendProtoAttributes(b, 11, sd.Attributes); err != nil {
+		return "", err
+	}
+	for _, a := range sd.Annotations {
+		m := appendProtoSint(nil, 1, a.Time.UnixMicro())
+		m = appendProtoString(m, 2, a.Message)
+		if m, err = appendProtoAttributes(m, 3, a.Attributes); err != nil {
+			return "", err
+		}
+		b = appendProtoMessage(b, 12, m)
+	}
+	for _, e := range sd.MessageEvents {
+		m := appendProtoSint(nil, 1, e.Time.UnixMicro())
+		m = appendProtoVarint(m, 2, uint64(e.EventType))
+		m = appendProtoVarint(m, 3, uint64(e.MessageID))
+		m = appendProtoVarint(m, 4, uint64(e.UncompressedByteSize))
+		m = appendProtoVarint(m, 5, uint64(e.CompressedByteSize))
+		b = appendProtoMessage(b, 13, m)
+	}
+	for _, l := range sd.Links {
+		m := appendProtoBytes(nil, 1, l.TraceID[:])
+		m = appendProtoBytes(m, 2, l.SpanID[:])
+		m = appendProtoVarint(m, 3, uint64(l.Type))
+		if m, err = appendProtoAttributes(m, 4, l.Attributes); err != nil {
+			return "", err
+		}
+		b = appendProtoMessage(b, 14, m)
+	}
+	if sd.HasRemoteParent {
+		b = appendProtoVarint(b, 15, 1)
+	}
+	b = appendProtoVarint(b, 16, uint64(sd.DroppedAttributeCount))
+	b = appendProtoVarint(b, 17, uint64(sd.DroppedAnnotationCount))
+	b = appendProtoVarint(b, 18, uint64(sd.DroppedMessageEventCount))
+	b = appendProtoVarint(b, 19, uint64(sd.DroppedLinkCount))
+	b = appendProtoVarint(b, 20, uint64(sd.ChildSpanCount))
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (protoCodec) Decode(value string) (*SpanData, error) {
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var sd SpanData
+	var start, duration int64
+	err = consumeProtoFields(b, func(num protowire.Number, v uint64, payload []byte) error {
+		switch num {
+		case 1:
+			return copyProtoID(sd.TraceID[:], payload)
+		case 2:
+			return copyProtoID(sd.SpanID[:], payload)
+		case 3:
+			return copyProtoID(sd.ParentSpanID[:], payload)
+		case 4:
+			sd.Name = string(payload)
+		case 5:
+			start = protowire.DecodeZigZag(v)
+		case 6:
+			duration = int64(v)
+		case 7:
+			sd.TraceOptions = TraceOptions(v)
+		case 8:
+			sd.SpanKind = int(int32(v))
+		case 9:
+			sd.Status.Code = int32(v)
+		case 10:
+			sd.Status.Message = string(payload)
+		case 11:
+			if sd.Attributes == nil {
+				sd.Attributes = make(map[string]interface{})
+			}
+			return decodeProtoAttribute(sd.Attributes, payload)
+		case 12:
+			var a Annotation
+			err := consumeProtoFields(payload, func(num protowire.Number, v uint64, payload []byte) error {
+				switch num {
+				case 1:
+					a.Time = time.UnixMicro(protowire.DecodeZigZag(v))
+				case 2:
+					a.Message = string(payload)
+				case 3:
+					if a.Attributes == nil {
+						a.Attributes = make(map[string]interface{})
+					}
+					return decodeProtoAttribute(a.Attributes, payload)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			sd.Annotations = append(sd.Annotations, a)
+		case 13:
+			var e MessageEvent
+			err := consumeProtoFields(payload, func(num protowire.Number, v uint64, payload []byte) error {
+				switch num {
+				case 1:
+					e.Time = time.UnixMicro(protowire.DecodeZigZag(v))
+				case 2:
+					e.EventType = MessageEventType(v)
+				case 3:
+					e.MessageID = int64(v)
+				case 4:
+					e.UncompressedByteSize = int64(v)
+				case 5:
+					e.CompressedByteSize = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			sd.MessageEvents = append(sd.MessageEvents, e)
+		case 14:
+			var l Link
+			err := consumeProtoFields(payload, func(num protowire.Number, v uint64, payload []byte) error {
+				switch num {
+				case 1:
+					return copyProtoID(l.TraceID[:], payload)
+				case 2:
+					return copyProtoID(l.SpanID[:], payload)
+				case 3:
+					l.Type = LinkType(v)
+				case 4:
+					if l.Attributes == nil {
+						l.Attributes = make(map[string]interface{})
+					}
+					return decodeProtoAttribute(l.Attributes, payload)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			sd.Links = append(sd.Links, l)
+		case 15:
+			sd.HasRemoteParent = protowire.DecodeBool(v)
+		case 16:
+			sd.DroppedAttributeCount = int(int32(v))
+		case 17:
+			sd.DroppedAnnotationCount = int(int32(v))
+		case 18:
+			sd.DroppedMessageEventCount = int(int32(v))
+		case 19:
+			sd.DroppedLinkCount = int(int32(v))
+		case 20:
+			sd.ChildSpanCount = int(int32(v))
+		case 21:
+			if v < 1 || v > CompactSchemaVersion {
+				return fmt.Errorf("%w %d", ErrUnsupportedCompactVersion, v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sd.StartTime = time.UnixMicro(start)
+	sd.EndTime = sd.StartTime.Add(time.Duration(duration) * time.Microsecond)
+	return &sd, nil
+}
+
+// appendProtoSpanHeader appends the fields shared by compact and complete
+// spans.
+func appendProtoSpanHeader(b []byte, sd *SpanData) []byte {
+	b = appendProtoBytes(b, 1, sd.TraceID[:])
+	b = appendProtoBytes(b, 2, sd.SpanID[:])
+	if sd.ParentSpanID != (SpanID{}) {
+		b = appendProtoBytes(b, 3, sd.ParentSpanID[:])
+	}
+	b = appendProtoString(b, 4, sd.Name)
+	b = appendProtoSint(b, 5, sd.StartTime.UnixMicro())
+	b = appendProtoVarint(b, 6, uint64(sd.EndTime.UnixMicro()-sd.StartTime.UnixMicro()))
+	return b
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendProtoSint(b []byte, num protowire.Number, v int64) []byte {
+	return appendProtoVarint(b, num, protowire.EncodeZigZag(v))
+}
+
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, m []byte) []byte {
+	return appendProtoBytes(b, num, m)
+}
+
+func appendProtoAttributes(b []byte, num protowire.Number, attrs map[string]interface{}) ([]byte, error) {
+	for k, v := range attrs {
+		m := appendProtoString(nil, 1, k)
+		switch v := v.(type) {
+		case string:
+			m = protowire.AppendTag(m, 2, protowire.BytesType)
+			m = protowire.AppendString(m, v)
+		case bool:
+			m = protowire.AppendTag(m, 3, protowire.VarintType)
+			m = protowire.AppendVarint(m, protowire.EncodeBool(v))
+		case int64:
+			m = protowire.AppendTag(m, 4, protowire.VarintType)
+			m = protowire.AppendVarint(m, uint64(v))
+		case float64:
+			m = protowire.AppendTag(m, 5, protowire.Fixed64Type)
+			m = protowire.AppendFixed64(m, math.Float64bits(v))
+		default:
+			return nil, fmt.Errorf("trace: unsupported attribute type %T for key %q", v, k)
+		}
+		b = appendProtoMessage(b, num, m)
+	}
+	return b, nil
+}
+
+// consumeProtoFields calls f for every field in b. Varint and fixed64
+// fields are passed in v, length-delimited fields in payload. Fields of other
+// wire types are skipped.
+func consumeProtoFields(b []byte, f func(num protowire.Number, v uint64, payload []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v uint64
+		var payload []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			payload, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := f(num, v, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeProtoAttribute(attrs map[string]interface{}, b []byte) error {
+	var key string
+	var value interface{}
+	err := consumeProtoFields(b, func(num protowire.Number, v uint64, payload []byte) error {
+		switch num {
+		case 1:
+			key = string(payload)
+		case 2:
+			value = string(payload)
+		case 3:
+			value = protowire.DecodeBool(v)
+		case 4:
+			value = int64(v)
+		case 5:
+			value = math.Float64frombits(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	attrs[key] = value
+	return nil
+}
+
+func copyProtoID(dst, payload []byte) error {
+	if len(payload) != len(dst) {
+		return fmt.Errorf("trace: invalid ID length %d, want %d", len(payload), len(dst))
+	}
+	copy(dst, payload)
+	return nil
+}
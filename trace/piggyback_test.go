@@ -0,0 +1,154 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testPiggybackSpan() *SpanData {
+	start := time.UnixMicro(1600000000123456)
+	return &SpanData{
+		SpanContext: SpanContext{
+			TraceID:      TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			SpanID:       SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+			TraceOptions: 1,
+		},
+		ParentSpanID: SpanID{8, 7, 6, 5, 4, 3, 2, 1},
+		SpanKind:     SpanKindServer,
+		Name:         "/hello",
+		StartTime:    start,
+		EndTime:      start.Add(1500 * time.Microsecond),
+		Attributes: map[string]interface{}{
+			"s": "v",
+			"b": true,
+			"i": int64(-42),
+			"f": 1.5,
+		},
+		Annotations: []Annotation{
+			{Time: start, Message: "annotation", Attributes: map[string]interface{}{"k": "v"}},
+		},
+		MessageEvents: []MessageEvent{
+			{Time: start, EventType: MessageEventTypeRecv, MessageID: 3, UncompressedByteSize: 100, CompressedByteSize: -1},
+		},
+		Status: Status{Code: StatusCodeUnavailable, Message: "unavailable"},
+		Links: []Link{
+			{TraceID: TraceID{2}, SpanID: SpanID{3}, Type: LinkTypeParent},
+		},
+		HasRemoteParent:       true,
+		DroppedAttributeCount: 2,
+		ChildSpanCount:        5,
+	}
+}
+
+func TestPiggybackCodecRoundTrip(t *testing.T) {
+	sd := testPiggybackSpan()
+	compact := &SpanData{
+		SpanContext:     SpanContext{TraceID: sd.TraceID, SpanID: sd.SpanID},
+		ParentSpanID:    sd.ParentSpanID,
+		Name:            sd.Name,
+		StartTime:       sd.StartTime,
+		EndTime:         sd.EndTime,
+		HasRemoteParent: true,
+	}
+	for name, codec := range map[string]PiggybackCodec{
+		"binary": BinaryCodec(),
+		"proto":  ProtoCodec(),
+		"json":   JSONCodec(),
+	} {
+		v, err := codec.EncodeServerless(sd)
+		if err != nil {
+			t.Fatalf("%s: EncodeServerless() error: %v", name, err)
+		}
+		got, err := codec.Decode(v)
+		if err != nil {
+			t.Fatalf("%s: Decode(%q) error: %v", name, v, err)
+		}
+		if !reflect.DeepEqual(got, compact) {
+			t.Errorf("%s: compact span = %+v; want %+v", name, got, compact)
+		}
+
+		if name == "json" {
+			// JSON turns numeric attributes into float64, so only check
+			// that the complete span decodes.
+			v, err := codec.EncodeSpanData(sd)
+			if err != nil {
+				t.Fatalf("%s: EncodeSpanData() error: %v", name, err)
+			}
+			if _, err := codec.Decode(v); err != nil {
+				t.Errorf("%s: Decode(%q) error: %v", name, v, err)
+			}
+			continue
+		}
+		v, err = codec.EncodeSpanData(sd)
+		if err != nil {
+			t.Fatalf("%s: EncodeSpanData() error: %v", name, err)
+		}
+		got, err = codec.Decode(v)
+		if err != nil {
+			t.Fatalf("%s: Decode(%q) error: %v", name, v, err)
+		}
+		if !reflect.DeepEqual(got, sd) {
+			t.Errorf("%s: span = %+v; want %+v", name, got, sd)
+		}
+	}
+}
+
+func TestPiggybackCodecRejectsGarbage(t *testing.T) {
+	for name, codec := range map[string]PiggybackCodec{
+		"binary": BinaryCodec(),
+		"proto":  ProtoCodec(),
+		"json":   JSONCodec(),
+	} {
+		if _, err := codec.Decode("AQID"); err == nil {
+			t.Errorf("%s: Decode() of garbage succeeded", name)
+		}
+	}
+}
+
+func TestPiggybackCodecInvocationAttributes(t *testing.T) {
+	sd := testPiggybackSpan()
+	sd.Attributes = map[string]interface{}{
+		"s":                      "v",
+		ColdStartAttribute:       true,
+		InitDurationAttribute:    int64(250000),
+		InvocationCountAttribute: int64(1),
+		FunctionNameAttribute:    "hello",
+		FunctionVersionAttribute: "3",
+	}
+	want := invocationAttributes(sd)
+	if len(want) != 5 {
+		t.Fatalf("invocationAttributes() = %v; want 5 attributes", want)
+	}
+	for name, codec := range map[string]PiggybackCodec{
+		"binary": BinaryCodec(),
+		"proto":  ProtoCodec(),
+		"json":   JSONCodec(),
+	} {
+		v, err := codec.EncodeServerless(sd)
+		if err != nil {
+			t.Fatalf("%s: EncodeServerless() error: %v", name, err)
+		}
+		got, err := codec.Decode(v)
+		if err != nil {
+			t.Fatalf("%s: Decode(%q) error: %v", name, v, err)
+		}
+		if !reflect.DeepEqual(got.Attributes, want) {
+			t.Errorf("%s: compact attributes = %v; want %v", name, got.Attributes, want)
+		}
+	}
+}
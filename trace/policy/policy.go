@@ -0,0 +1,216 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy contains composable classifiers deciding how a finished
+// span is handled by trace.Span.EndAndAggregate and trace.Span.EndAtClient.
+//
+// An exporter can embed a Policy to implement FilterSpan:
+//
+//	type MyExporter struct {
+//		policy.Policy
+//	}
+//
+//	e := &MyExporter{Policy: policy.Default()}
+package policy // import "github.com/Yangfisher1/opencensus-go/trace/policy"
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+// Classifier classifies a finished span. It returns false if it has no
+// opinion on the span, leaving the decision to the next classifier.
+type Classifier func(sd *trace.SpanData) (trace.ErrorType, bool)
+
+// Policy classifies spans by consulting its classifiers in order. The first
+// classifier with an opinion decides; if none has one, the span is
+// trace.OK. The zero value classifies every span as trace.OK.
+type Policy struct {
+	Classifiers []Classifier
+}
+
+// New returns a Policy consulting the given classifiers in order.
+func New(classifiers ...Classifier) Policy {
+	return Policy{Classifiers: classifiers}
+}
+
+// Default returns a Policy that reports failed spans and user spans
+// immediately, aggregates at aggregation points and ships spans slower than
+// the 99th percentile of their name in full.
+func Default() Policy {
+	return New(
+		ErrorStatus(),
+		UserSpan(),
+		AggregationPoint(),
+		LatencyPercentile(99, 1000, 100),
+	)
+}
+
+// FilterSpan implements the FilterSpan method of trace.AggregatingExporter.
+func (p Policy) FilterSpan(sd *trace.SpanData) trace.ErrorType {
+	for _, c := range p.Classifiers {
+		if t, ok := c(sd); ok {
+			return t
+		}
+	}
+	return trace.OK
+}
+
+// ErrorStatus classifies spans with a non-OK status as trace.Error.
+func ErrorStatus() Classifier {
+	return func(sd *trace.SpanData) (trace.ErrorType, bool) {
+		return trace.Error, sd.Status.Code != trace.StatusCodeOK
+	}
+}
+
+// Attribute classifies spans whose attribute key equals value as t.
+func Attribute(key string, value interface{}, t trace.ErrorType) Classifier {
+	return func(sd *trace.SpanData) (trace.ErrorType, bool) {
+		v, ok := sd.Attributes[key]
+		return t, ok && v == value
+	}
+}
+
+// UserSpan classifies spans marked with the "usr" attribute, as set by
+// ochttp.Transport.IsUserSpan, as trace.UserSpec.
+func UserSpan() Classifier {
+	return Attribute("usr", "y", trace.UserSpec)
+}
+
+// AggregationPoint classifies spans marked with the "agg" attribute, as set
+// by ochttp.Transport.IsAggregationPoint, as trace.Aggregate.
+func AggregationPoint() Classifier {
+	return Attribute("agg", "y", trace.Aggregate)
+}
+
+// LatencyPercentile classifies spans taking longer than the given
+// percentile of the most recent spans with the same name as
+// trace.PerformanceDown. Up to window latencies are kept per span name, and
+// no span is classified before minSamples of its name have been seen. The
+// percentile is cached per span name and recomputed after every
+// window/10 new spans of that name.
+func LatencyPercentile(percentile float64, window, minSamples int) Classifier {
+	if window < 1 {
+		window = 1
+	}
+	l := &latencies{
+		percentile: percentile,
+		window:     window,
+		minSamples: minSamples,
+		byName:     make(map[string]*latencyRing),
+	}
+	return l.classify
+}
+
+// Baseline classifies spans taking longer than the q-quantile, with q
+// between 0 and 1, of the latency baseline the trace package keeps for
+// their name as trace.PerformanceDown. Baselines must be enabled with
+// trace.Config.LatencyBaselineWindow; no span is classified while its
+// baseline holds fewer than minSamples spans.
+func Baseline(q float64, minSamples int) Classifier {
+	return func(sd *trace.SpanData) (trace.ErrorType, bool) {
+		threshold, n := trace.LatencyQuantile(sd.Name, q)
+		if n == 0 || n < minSamples {
+			return trace.PerformanceDown, false
+		}
+		return trace.PerformanceDown, sd.EndTime.Sub(sd.StartTime) > threshold
+	}
+}
+
+// refreshDivisor controls how often a cached percentile is recomputed:
+// every window/refreshDivisor new samples of its span name.
+const refreshDivisor = 10
+
+type latencies struct {
+	percentile float64
+	window     int
+	minSamples int
+
+	mu     sync.Mutex // guards byName
+	byName map[string]*latencyRing
+}
+
+// latencyRing holds the most recent latencies of a span name and the
+// percentile last computed from them.
+type latencyRing struct {
+	mu        sync.Mutex
+	samples   []time.Duration
+	next      int
+	threshold time.Duration
+	stale     int // samples added since threshold was computed
+	computed  bool
+}
+
+func (l *latencies) ring(name string) *latencyRing {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.byName[name]
+	if !ok {
+		r = &latencyRing{samples: make([]time.Duration, 0, l.window)}
+		l.byName[name] = r
+	}
+	return r
+}
+
+func (l *latencies) classify(sd *trace.SpanData) (trace.ErrorType, bool) {
+	d := sd.EndTime.Sub(sd.StartTime)
+	r := l.ring(sd.Name)
+
+	r.mu.Lock()
+	enough := len(r.samples) >= l.minSamples && len(r.samples) > 0
+	if enough && (!r.computed || r.stale >= l.refreshEvery()) {
+		r.threshold = percentileOf(r.samples, l.percentile)
+		r.stale = 0
+		r.computed = true
+	}
+	threshold := r.threshold
+	if len(r.samples) < l.window {
+		r.samples = append(r.samples, d)
+	} else {
+		r.samples[r.next] = d
+		r.next = (r.next + 1) % l.window
+	}
+	r.stale++
+	r.mu.Unlock()
+
+	return trace.PerformanceDown, enough && d > threshold
+}
+
+// refreshEvery returns the number of samples after which a cached
+// percentile is recomputed.
+func (l *latencies) refreshEvery() int {
+	if n := l.window / refreshDivisor; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// percentileOf returns the p-th percentile of samples using the
+// nearest-rank method.
+func percentileOf(samples []time.Duration, p float64) time.Duration {
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	} else if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
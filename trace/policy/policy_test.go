@@ -0,0 +1,122 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+func span(name string, d time.Duration, attrs map[string]interface{}, code int32) *trace.SpanData {
+	start := time.Now()
+	return &trace.SpanData{
+		Name:       name,
+		StartTime:  start,
+		EndTime:    start.Add(d),
+		Attributes: attrs,
+		Status:     trace.Status{Code: code},
+	}
+}
+
+func TestPolicyOrder(t *testing.T) {
+	p := New(ErrorStatus(), UserSpan(), AggregationPoint())
+	tests := []struct {
+		name string
+		sd   *trace.SpanData
+		want trace.ErrorType
+	}{
+		{"plain", span("a", time.Millisecond, nil, 0), trace.OK},
+		{"error", span("a", time.Millisecond, map[string]interface{}{"agg": "y"}, trace.StatusCodeInternal), trace.Error},
+		{"user", span("a", time.Millisecond, map[string]interface{}{"usr": "y", "agg": "y"}, 0), trace.UserSpec},
+		{"aggregate", span("a", time.Millisecond, map[string]interface{}{"agg": "y"}, 0), trace.Aggregate},
+		{"other value", span("a", time.Millisecond, map[string]interface{}{"agg": "n"}, 0), trace.OK},
+	}
+	for _, tt := range tests {
+		if got := p.FilterSpan(tt.sd); got != tt.want {
+			t.Errorf("%s: FilterSpan() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := (Policy{}).FilterSpan(tests[1].sd); got != trace.OK {
+		t.Errorf("zero Policy: FilterSpan() = %v; want OK", got)
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	c := LatencyPercentile(90, 10, 5)
+	for i := 0; i < 4; i++ {
+		if _, ok := c(span("a", time.Second, nil, 0)); ok {
+			t.Fatalf("span %d classified before minSamples", i)
+		}
+	}
+	for i := 1; i <= 10; i++ {
+		c(span("a", time.Duration(i)*time.Millisecond, nil, 0))
+	}
+	if typ, ok := c(span("a", 20*time.Millisecond, nil, 0)); !ok || typ != trace.PerformanceDown {
+		t.Errorf("slow span: got (%v, %v); want (PerformanceDown, true)", typ, ok)
+	}
+	if _, ok := c(span("a", 2*time.Millisecond, nil, 0)); ok {
+		t.Errorf("fast span classified as PerformanceDown")
+	}
+	if _, ok := c(span("b", time.Hour, nil, 0)); ok {
+		t.Errorf("span of another name classified before minSamples")
+	}
+}
+
+func TestLatencyPercentileRefresh(t *testing.T) {
+	c := LatencyPercentile(50, 100, 10)
+	for i := 0; i < 10; i++ {
+		c(span("a", 10*time.Millisecond, nil, 0))
+	}
+	// The median stays cached at 10ms for the next 10 spans.
+	for i := 0; i < 10; i++ {
+		if _, ok := c(span("a", time.Second, nil, 0)); !ok {
+			t.Fatalf("span %d not classified against the cached percentile", i)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		c(span("a", time.Second, nil, 0))
+	}
+	if _, ok := c(span("a", time.Second, nil, 0)); ok {
+		t.Errorf("span classified against a stale percentile")
+	}
+}
+
+type nopExporter struct{}
+
+func (nopExporter) ExportSpan(*trace.SpanData) {}
+
+func TestBaseline(t *testing.T) {
+	trace.ApplyConfig(trace.Config{LatencyBaselineWindow: time.Minute})
+	trace.RegisterExporter(nopExporter{})
+	defer trace.UnregisterExporter(nopExporter{})
+	c := Baseline(0.9, 10)
+	if _, ok := c(span("baseline", time.Hour, nil, 0)); ok {
+		t.Fatalf("span classified without a baseline")
+	}
+	for i := 1; i <= 20; i++ {
+		_, s := trace.StartSpan(context.Background(), "baseline", trace.WithSampler(trace.AlwaysSample()))
+		time.Sleep(time.Millisecond)
+		s.End()
+	}
+	if typ, ok := c(span("baseline", time.Hour, nil, 0)); !ok || typ != trace.PerformanceDown {
+		t.Errorf("slow span: got (%v, %v); want (PerformanceDown, true)", typ, ok)
+	}
+	if _, ok := c(span("baseline", 0, nil, 0)); ok {
+		t.Errorf("fast span classified as PerformanceDown")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// SpanProcessor is notified when spans that record events start and end.
+// Processors are called synchronously, in the order they were registered,
+// and should return quickly.
+type SpanProcessor interface {
+	// OnStart is called with the context of a new span, which holds the
+	// span and the values of its parent context. It can be used to add
+	// attributes to the span, such as a tenant ID from the context.
+	OnStart(ctx context.Context, s *Span)
+
+	// OnEnd is called with the data of an ended span before it is stored
+	// in the local span store, piggybacked or exported. It can modify sd,
+	// for instance to remove sensitive attributes.
+	OnEnd(sd *SpanData)
+}
+
+var (
+	processorMu sync.Mutex
+	processors  atomic.Value // []SpanProcessor
+)
+
+// RegisterSpanProcessor adds p to the end of the list of SpanProcessors.
+//
+// Binaries can register span processors, libraries shouldn't register
+// span processors.
+func RegisterSpanProcessor(p SpanProcessor) {
+	processorMu.Lock()
+	old := spanProcessors()
+	new := make([]SpanProcessor, len(old), len(old)+1)
+	copy(new, old)
+	processors.Store(append(new, p))
+	processorMu.Unlock()
+}
+
+// UnregisterSpanProcessor removes p from the list of SpanProcessors.
+func UnregisterSpanProcessor(p SpanProcessor) {
+	processorMu.Lock()
+	var new []SpanProcessor
+	for _, q := range spanProcessors() {
+		if q != p {
+			new = append(new, q)
+		}
+	}
+	processors.Store(new)
+	processorMu.Unlock()
+}
+
+func spanProcessors() []SpanProcessor {
+	ps, _ := processors.Load().([]SpanProcessor)
+	return ps
+}
+
+// startSpanProcessors calls OnStart of the registered processors if s
+// records events.
+func startSpanProcessors(ctx context.Context, s *Span) {
+	if !s.IsRecordingEvents() {
+		return
+	}
+	for _, p := range spanProcessors() {
+		p.OnStart(ctx, s)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type tenantKey struct{}
+
+// tenantProcessor adds the tenant in the context to spans at start, and
+// appends its name to a list of calls at end.
+type tenantProcessor struct {
+	name  string
+	calls *[]string
+}
+
+func (p *tenantProcessor) OnStart(ctx context.Context, s *Span) {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		s.AddAttributes(StringAttribute("tenant", tenant))
+	}
+	if FromContext(ctx) != s {
+		*p.calls = append(*p.calls, p.name+": span not in context")
+	}
+}
+
+func (p *tenantProcessor) OnEnd(sd *SpanData) {
+	*p.calls = append(*p.calls, p.name)
+	sd.Name = strings.TrimPrefix(sd.Name, "secret-")
+}
+
+func TestSpanProcessors(t *testing.T) {
+	var calls []string
+	first := &tenantProcessor{name: "first", calls: &calls}
+	second := &tenantProcessor{name: "second", calls: &calls}
+	RegisterSpanProcessor(first)
+	RegisterSpanProcessor(second)
+	defer UnregisterSpanProcessor(second)
+	e := &plainExporter{}
+	RegisterExporter(e)
+	defer UnregisterExporter(e)
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	_, span := StartSpan(ctx, "secret-span", WithSampler(AlwaysSample()))
+	span.End()
+
+	if got, want := strings.Join(calls, ","), "first,second"; got != want {
+		t.Errorf("processor calls = %q; want %q", got, want)
+	}
+	if len(e.spans) != 1 {
+		t.Fatalf("exported %d spans; want 1", len(e.spans))
+	}
+	if sd := e.spans[0]; sd.Name != "span" || sd.Attributes["tenant"] != "acme" {
+		t.Errorf("exported span %q with attributes %v; want renamed span with the tenant", sd.Name, sd.Attributes)
+	}
+
+	UnregisterSpanProcessor(first)
+	calls = nil
+	_, span = StartSpan(context.Background(), "other", WithSampler(NeverSample()))
+	span.End()
+	_, span = StartSpan(context.Background(), "other", WithSampler(AlwaysSample()))
+	span.End()
+	if got, want := strings.Join(calls, ","), "second"; got != want {
+		t.Errorf("processor calls after unregistering = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "regexp"
+
+// RedactedValue replaces the values redacted by a Redactor.
+const RedactedValue = "[REDACTED]"
+
+// KeyRule redacts the attributes with a given key.
+type KeyRule struct {
+	Key string
+
+	// Drop removes the attribute instead of replacing its value with
+	// RedactedValue.
+	Drop bool
+}
+
+// ValueRule replaces the parts of strings matching Pattern with
+// Replacement, which can refer to submatches as in
+// regexp.Regexp.ReplaceAllString. An empty Replacement means
+// RedactedValue.
+type ValueRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Redactor scrubs sensitive data, such as user identifiers in URLs, from
+// spans. Set it with Config.Redactor to apply it to every span before it is
+// handed to span processors, stored for zpages, piggybacked or exported.
+type Redactor struct {
+	// KeyRules apply to the attributes of spans and of their annotations.
+	KeyRules []KeyRule
+
+	// ValueRules apply, in order, to span names, annotation messages and
+	// the string values of the attributes kept by KeyRules.
+	ValueRules []ValueRule
+}
+
+// Redact applies the rules of r to sd. Attribute maps are replaced rather
+// than modified, as they may be shared with the span sd was made from.
+func (r *Redactor) Redact(sd *SpanData) {
+	if r == nil {
+		return
+	}
+	sd.Name = r.redactString(sd.Name)
+	sd.Attributes = r.redactAttributes(sd.Attributes)
+	if len(sd.Annotations) > 0 {
+		annotations := make([]Annotation, len(sd.Annotations))
+		for i, a := range sd.Annotations {
+			a.Message = r.redactString(a.Message)
+			a.Attributes = r.redactAttributes(a.Attributes)
+			annotations[i] = a
+		}
+		sd.Annotations = annotations
+	}
+}
+
+// redactName returns name with the ValueRules applied.
+func (r *Redactor) redactName(name string) string {
+	if r == nil {
+		return name
+	}
+	return r.redactString(name)
+}
+
+func (r *Redactor) redactAttributes(attrs map[string]interface{}) map[string]interface{} {
+	if len(attrs) == 0 {
+		return attrs
+	}
+	out := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		out[k] = v
+	}
+	for _, rule := range r.KeyRules {
+		if _, ok := out[rule.Key]; !ok {
+			continue
+		}
+		if rule.Drop {
+			delete(out, rule.Key)
+		} else {
+			out[rule.Key] = RedactedValue
+		}
+	}
+	for k, v := range out {
+		if s, ok := v.(string); ok && s != RedactedValue {
+			out[k] = r.redactString(s)
+		}
+	}
+	return out
+}
+
+func (r *Redactor) redactString(s string) string {
+	for _, rule := range r.ValueRules {
+		repl := rule.Replacement
+		if repl == "" {
+			repl = RedactedValue
+		}
+		s = rule.Pattern.ReplaceAllString(s, repl)
+	}
+	return s
+}
@@ -0,0 +1,113 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func testRedactor() *Redactor {
+	return &Redactor{
+		KeyRules: []KeyRule{
+			{Key: "http.user_agent"},
+			{Key: "auth.token", Drop: true},
+		},
+		ValueRules: []ValueRule{
+			{Pattern: regexp.MustCompile(`/users/[^/?]+`), Replacement: "/users/:id"},
+			{Pattern: regexp.MustCompile(`[\w.]+@[\w.]+`)},
+		},
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	shared := map[string]interface{}{"email": "bob@example.com"}
+	sd := &SpanData{
+		Name: "/users/42/orders",
+		Attributes: map[string]interface{}{
+			"http.url":        "https://example.com/users/42?x=1",
+			"http.user_agent": "curl/7.0",
+			"auth.token":      "secret",
+			"http.status":     int64(200),
+		},
+		Annotations: []Annotation{
+			{Message: "mail sent to bob@example.com", Attributes: shared},
+		},
+	}
+	testRedactor().Redact(sd)
+
+	if want := "/users/:id/orders"; sd.Name != want {
+		t.Errorf("Name = %q; want %q", sd.Name, want)
+	}
+	wantAttrs := map[string]interface{}{
+		"http.url":        "https://example.com/users/:id?x=1",
+		"http.user_agent": RedactedValue,
+		"http.status":     int64(200),
+	}
+	if !reflect.DeepEqual(sd.Attributes, wantAttrs) {
+		t.Errorf("Attributes = %v; want %v", sd.Attributes, wantAttrs)
+	}
+	a := sd.Annotations[0]
+	if want := "mail sent to " + RedactedValue; a.Message != want {
+		t.Errorf("annotation message = %q; want %q", a.Message, want)
+	}
+	if a.Attributes["email"] != RedactedValue {
+		t.Errorf("annotation attributes = %v; want the email redacted", a.Attributes)
+	}
+	if shared["email"] != "bob@example.com" {
+		t.Errorf("Redact modified a shared attribute map: %v", shared)
+	}
+}
+
+func TestRedactorAppliedAtEnd(t *testing.T) {
+	old := config.Load()
+	defer config.Store(old)
+	ApplyConfig(Config{Redactor: testRedactor()})
+	e := &plainExporter{}
+	RegisterExporter(e)
+	defer UnregisterExporter(e)
+	var seen []string
+	p := &recordingProcessor{seen: &seen}
+	RegisterSpanProcessor(p)
+	defer UnregisterSpanProcessor(p)
+
+	_, span := StartSpan(context.Background(), "/users/7", WithSampler(AlwaysSample()))
+	span.AddAttributes(StringAttribute("http.user_agent", "curl/7.0"))
+	span.Annotate(nil, "user bob@example.com")
+	span.End()
+
+	if len(e.spans) != 1 {
+		t.Fatalf("exported %d spans; want 1", len(e.spans))
+	}
+	sd := e.spans[0]
+	if sd.Name != "/users/:id" || sd.Attributes["http.user_agent"] != RedactedValue || sd.Annotations[0].Message != "user "+RedactedValue {
+		t.Errorf("exported span was not redacted: %+v", sd)
+	}
+	if len(seen) != 1 || seen[0] != "/users/:id" {
+		t.Errorf("span processors saw %v; want the redacted name", seen)
+	}
+}
+
+type recordingProcessor struct {
+	seen *[]string
+}
+
+func (p *recordingProcessor) OnStart(context.Context, *Span) {}
+
+func (p *recordingProcessor) OnEnd(sd *SpanData) {
+	*p.seen = append(*p.seen, sd.Name)
+}
@@ -16,6 +16,8 @@ package trace
 
 import (
 	"encoding/binary"
+	"sync"
+	"time"
 )
 
 const defaultSamplingProbability = 1e-4
@@ -73,3 +75,98 @@ func NeverSample() Sampler {
 		return SamplingDecision{Sample: false}
 	}
 }
+
+// RateLimitingSampler returns a Sampler that samples up to perSecond new
+// traces per second, with bursts of up to perSecond traces, or one if it is
+// lower.
+//
+// It also samples spans whose parents are sampled, which do not count
+// against the limit.
+func RateLimitingSampler(perSecond float64) Sampler {
+	if !(perSecond > 0) {
+		return NeverSample()
+	}
+	burst := perSecond
+	if burst < 1 {
+		burst = 1
+	}
+	b := &tokenBucket{rate: perSecond, burst: burst, tokens: burst, last: time.Now()}
+	return Sampler(func(p SamplingParameters) SamplingDecision {
+		if p.ParentContext.IsSampled() {
+			return SamplingDecision{Sample: true}
+		}
+		return SamplingDecision{Sample: b.take(time.Now())}
+	})
+}
+
+type tokenBucket struct {
+	rate, burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take reports whether a token was available at now, and takes it.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ParentBasedSamplers holds the samplers ParentBased consults for spans
+// with a parent, depending on whether the parent is remote and sampled.
+// Nil samplers default to AlwaysSample for sampled parents and NeverSample
+// for unsampled ones.
+type ParentBasedSamplers struct {
+	RemoteSampled    Sampler
+	RemoteNotSampled Sampler
+	LocalSampled     Sampler
+	LocalNotSampled  Sampler
+}
+
+// ParentBased returns a Sampler that consults root for spans without a
+// parent, and the sampler of s matching the parent for other spans. With
+// the zero ParentBasedSamplers it follows the decision of the parent.
+//
+// Local children of local spans only consult a sampler passed with
+// WithSampler; otherwise they keep the decision of their parent.
+func ParentBased(root Sampler, s ParentBasedSamplers) Sampler {
+	if s.RemoteSampled == nil {
+		s.RemoteSampled = AlwaysSample()
+	}
+	if s.RemoteNotSampled == nil {
+		s.RemoteNotSampled = NeverSample()
+	}
+	if s.LocalSampled == nil {
+		s.LocalSampled = AlwaysSample()
+	}
+	if s.LocalNotSampled == nil {
+		s.LocalNotSampled = NeverSample()
+	}
+	return Sampler(func(p SamplingParameters) SamplingDecision {
+		switch {
+		case p.ParentContext == (SpanContext{}):
+			return root(p)
+		case p.HasRemoteParent && p.ParentContext.IsSampled():
+			return s.RemoteSampled(p)
+		case p.HasRemoteParent:
+			return s.RemoteNotSampled(p)
+		case p.ParentContext.IsSampled():
+			return s.LocalSampled(p)
+		default:
+			return s.LocalNotSampled(p)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{rate: 2, burst: 2, tokens: 2, last: now}
+	if !b.take(now) || !b.take(now) {
+		t.Fatalf("tokens within the burst were not available")
+	}
+	if b.take(now) {
+		t.Errorf("token beyond the burst was available")
+	}
+	if !b.take(now.Add(500 * time.Millisecond)) {
+		t.Errorf("token was not refilled after 500ms at 2 per second")
+	}
+	if b.take(now.Add(500 * time.Millisecond)) {
+		t.Errorf("more tokens were refilled than the rate allows")
+	}
+	if !b.take(now.Add(time.Hour)) || !b.take(now.Add(time.Hour)) || b.take(now.Add(time.Hour)) {
+		t.Errorf("refill after an idle hour was not capped at the burst")
+	}
+}
+
+func TestRateLimitingSampler(t *testing.T) {
+	s := RateLimitingSampler(3)
+	sampled := 0
+	for i := 0; i < 10; i++ {
+		if s(SamplingParameters{}).Sample {
+			sampled++
+		}
+	}
+	if sampled != 3 {
+		t.Errorf("sampled %d of 10 new traces; want the burst of 3", sampled)
+	}
+	parent := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}, TraceOptions: 1}
+	if !s(SamplingParameters{ParentContext: parent}).Sample {
+		t.Errorf("span with a sampled parent was not sampled past the limit")
+	}
+	if RateLimitingSampler(0)(SamplingParameters{}).Sample {
+		t.Errorf("RateLimitingSampler(0) sampled a trace")
+	}
+}
+
+func TestParentBased(t *testing.T) {
+	sampled := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}, TraceOptions: 1}
+	unsampled := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}}
+	tests := []struct {
+		name   string
+		params SamplingParameters
+		want   bool
+	}{
+		{"root", SamplingParameters{}, true},
+		{"remote sampled", SamplingParameters{ParentContext: sampled, HasRemoteParent: true}, false},
+		{"remote unsampled", SamplingParameters{ParentContext: unsampled, HasRemoteParent: true}, true},
+		{"local sampled", SamplingParameters{ParentContext: sampled}, true},
+		{"local unsampled", SamplingParameters{ParentContext: unsampled}, false},
+	}
+	s := ParentBased(AlwaysSample(), ParentBasedSamplers{
+		RemoteSampled:    NeverSample(),
+		RemoteNotSampled: AlwaysSample(),
+	})
+	for _, tt := range tests {
+		if got := s(tt.params).Sample; got != tt.want {
+			t.Errorf("%s: Sample = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+
+	follow := ParentBased(NeverSample(), ParentBasedSamplers{})
+	for _, tt := range tests {
+		want := tt.params.ParentContext.IsSampled()
+		if got := follow(tt.params).Sample; got != want {
+			t.Errorf("%s: default Sample = %v; want the parent decision %v", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Flusher may be implemented by exporters that buffer spans, such as
+// BatchSpanProcessor, to have them delivered by Flush and Shutdown.
+type Flusher interface {
+	// Flush delivers the spans exported so far and waits until that is
+	// done, or until ctx is done.
+	Flush(ctx context.Context) error
+}
+
+// shutdowner is implemented by exporters with resources to release, such
+// as BatchSpanProcessor.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// isShutdown is set by Shutdown, accessed atomically.
+var isShutdown int32
+
+// Flush flushes all registered exporters implementing Flusher, so that the
+// spans ended so far reach their destination. It is meant to be called
+// before a serverless function is frozen. It returns the first error
+// returned by an exporter, or ctx.Err() if ctx is done first.
+func Flush(ctx context.Context) error {
+	exp, _ := exporters.Load().(exportersMap)
+	var firstErr error
+	for e := range exp {
+		f, ok := e.(Flusher)
+		if !ok {
+			continue
+		}
+		if err := f.Flush(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Shutdown stops recording new spans, flushes all registered exporters and
+// shuts down those that have a Shutdown(context.Context) error method, such
+// as BatchSpanProcessor. Spans started afterwards are not recorded, though
+// their span contexts are still propagated. It is meant to be called before
+// the process exits, and returns the first error encountered.
+func Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&isShutdown, 1)
+	firstErr := Flush(ctx)
+	exp, _ := exporters.Load().(exportersMap)
+	for e := range exp {
+		s, ok := e.(shutdowner)
+		if !ok {
+			continue
+		}
+		if err := s.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func shutDown() bool {
+	return atomic.LoadInt32(&isShutdown) != 0
+}
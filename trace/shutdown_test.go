@@ -0,0 +1,62 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlushAndShutdown(t *testing.T) {
+	defer atomic.StoreInt32(&isShutdown, 0)
+	e := &gatedExporter{}
+	p := NewBatchSpanProcessor(e, BatchSpanProcessorOptions{BatchTimeout: time.Hour})
+	RegisterExporter(p)
+	defer UnregisterExporter(p)
+
+	_, span := StartSpan(context.Background(), "flushed", WithSampler(AlwaysSample()))
+	span.End()
+	if got := e.exported(); len(got) != 0 {
+		t.Fatalf("exported %v before Flush; want none", got)
+	}
+	if err := Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	if got := e.exported(); len(got) != 1 {
+		t.Fatalf("exported %v after Flush; want [flushed]", got)
+	}
+
+	_, pending := StartSpan(context.Background(), "pending", WithSampler(AlwaysSample()))
+	pending.End()
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+	if got := e.exported(); len(got) != 2 {
+		t.Errorf("exported %v after Shutdown; want [flushed pending]", got)
+	}
+	_, late := StartSpan(context.Background(), "late", WithSampler(AlwaysSample()))
+	if late.IsRecordingEvents() {
+		t.Errorf("span started after Shutdown is recording events")
+	}
+	if !late.SpanContext().IsSampled() {
+		t.Errorf("span started after Shutdown lost its sampling decision")
+	}
+	late.End()
+	if got := p.DroppedSpans(); got != 0 {
+		t.Errorf("DroppedSpans() = %d; want 0, as late spans are not recorded", got)
+	}
+}
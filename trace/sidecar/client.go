@@ -0,0 +1,265 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sidecar delivers serverless spans out of band, for spans that
+// have no response to ride on: spans of background goroutines,
+// fire-and-forget invocations and queue consumers.
+//
+// A Client batches encoded spans and POSTs them to a collector, where a
+// Receiver hands them to an aggregation point as if they had arrived in a
+// trace.AggregationHeader trailer.
+package sidecar // import "github.com/Yangfisher1/opencensus-go/trace/sidecar"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+const (
+	defaultBatchSize     = 64
+	defaultFlushInterval = time.Second
+	defaultMaxQueueSize  = 2048
+	defaultTimeout       = 10 * time.Second
+)
+
+var errNoURL = errors.New("sidecar: collector URL is empty")
+
+// Options configures a Client.
+type Options struct {
+	// URL is the collector endpoint batches are POSTed to, typically served
+	// by a Receiver.
+	URL string
+
+	// HTTPClient sends the batches. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Codec encodes the spans passed to ExportSpan. It must match the codec
+	// of the aggregation point. If nil, trace.JSONCodec is used.
+	Codec trace.PiggybackCodec
+
+	// Schema selects the optional fields of the spans passed to
+	// ExportSpan.
+	Schema trace.CompactSchema
+
+	// BatchSize is the number of spans that triggers sending a batch
+	// before the flush interval elapses. Defaults to 64.
+	BatchSize int
+
+	// FlushInterval is the longest time a span waits before it is sent.
+	// Defaults to one second.
+	FlushInterval time.Duration
+
+	// MaxQueueSize is the number of spans that can wait to be sent. Spans
+	// queued while it is reached are dropped. Defaults to 2048.
+	MaxQueueSize int
+
+	// Timeout bounds the time sending a batch may take, including reading
+	// the response. Defaults to ten seconds.
+	Timeout time.Duration
+
+	// OnError is called with errors encoding spans or sending batches.
+	OnError func(error)
+}
+
+// Client batches encoded spans and POSTs them to a collector. It is a
+// trace.Exporter, and its Fallback method can be used as
+// ochttp.Handler.AggregationFallback. Batches that cannot be sent are
+// dropped, as are spans queued while the queue is full. Call Stop to send
+// the remaining spans and release its goroutine.
+type Client struct {
+	opts Options
+
+	mu      sync.Mutex
+	pending []string
+
+	dropped int64 // accessed atomically
+
+	sendMu   sync.Mutex // serializes batches
+	flushc   chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+var _ trace.Exporter = (*Client)(nil)
+
+// NewClient returns a Client sending to o.URL and starts its flush loop.
+func NewClient(o Options) (*Client, error) {
+	if o.URL == "" {
+		return nil, errNoURL
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	if o.Codec == nil {
+		o.Codec = trace.JSONCodec()
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = defaultFlushInterval
+	}
+	if o.MaxQueueSize <= 0 {
+		o.MaxQueueSize = defaultMaxQueueSize
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	c := &Client{
+		opts:   o,
+		flushc: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.loop()
+	return c, nil
+}
+
+// ExportSpan encodes sd as a compact span and queues it.
+func (c *Client) ExportSpan(sd *trace.SpanData) {
+	v, err := c.opts.Codec.EncodeCompact(sd, c.opts.Schema)
+	if err != nil {
+		c.onError(err)
+		return
+	}
+	c.Send(v)
+}
+
+// Fallback queues the aggregated spans of a response that cannot carry
+// them. It has the signature of ochttp.Handler.AggregationFallback.
+func (c *Client) Fallback(r *http.Request, values []string) {
+	c.Send(values...)
+}
+
+// Send queues trace.AggregationHeader values, which are sent unchanged.
+// Values that do not fit in the queue are dropped.
+func (c *Client) Send(values ...string) {
+	if len(values) == 0 {
+		return
+	}
+	c.mu.Lock()
+	if room := c.opts.MaxQueueSize - len(c.pending); len(values) > room {
+		if room < 0 {
+			room = 0
+		}
+		atomic.AddInt64(&c.dropped, int64(len(values)-room))
+		values = values[:room]
+	}
+	c.pending = append(c.pending, values...)
+	full := len(c.pending) >= c.opts.BatchSize
+	c.mu.Unlock()
+	if full {
+		select {
+		case c.flushc <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// DroppedSpans returns the number of values dropped because the queue was
+// full or their batch could not be sent.
+func (c *Client) DroppedSpans() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// Flush sends the queued spans and waits for the collector to accept them.
+// It stops at the first batch that cannot be sent within Options.Timeout.
+func (c *Client) Flush() error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	for {
+		c.mu.Lock()
+		batch := c.pending
+		if len(batch) > c.opts.BatchSize {
+			batch = batch[:c.opts.BatchSize]
+		}
+		c.pending = c.pending[len(batch):]
+		c.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := c.post(batch); err != nil {
+			atomic.AddInt64(&c.dropped, int64(len(batch)))
+			return err
+		}
+	}
+}
+
+// Stop sends the queued spans and stops the flush loop. Spans queued after
+// Stop are not sent until Flush is called.
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.quit)
+		<-c.done
+	})
+}
+
+func (c *Client) loop() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.flushc:
+		case <-c.quit:
+			if err := c.Flush(); err != nil {
+				c.onError(err)
+			}
+			return
+		}
+		if err := c.Flush(); err != nil {
+			c.onError(err)
+		}
+	}
+}
+
+func (c *Client) post(batch []string) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sidecar: collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+func (c *Client) onError(err error) {
+	if c.opts.OnError != nil {
+		c.opts.OnError(err)
+	}
+}
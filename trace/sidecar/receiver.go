@@ -0,0 +1,55 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sidecar
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+)
+
+// contentType is the media type of a batch: a JSON array of
+// trace.AggregationHeader values.
+const contentType = "application/json"
+
+// maxBatchBytes bounds the size of a batch accepted by a Receiver.
+const maxBatchBytes = 4 << 20
+
+// Receiver is the collector endpoint of Clients. It hands each batch to
+// Aggregator as the AggregationHeader values of a header, like the trailer
+// of a response reaching an aggregation point.
+type Receiver struct {
+	Aggregator trace.AggregatingExporter
+}
+
+var _ http.Handler = (*Receiver)(nil)
+
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var batch []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes)).Decode(&batch); err != nil {
+		http.Error(w, "malformed batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(batch) > 0 {
+		rc.Aggregator.AggregateSpanFromHeader(http.Header{trace.AggregationHeader: batch})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
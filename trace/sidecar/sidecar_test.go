@@ -0,0 +1,193 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sidecar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Yangfisher1/opencensus-go/trace"
+	"github.com/Yangfisher1/opencensus-go/trace/aggregator"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (e *recordingExporter) ExportSpan(sd *trace.SpanData) {
+	e.mu.Lock()
+	e.spans = append(e.spans, sd)
+	e.mu.Unlock()
+}
+
+func (e *recordingExporter) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.spans)
+}
+
+func testSpan(id byte, name string) *trace.SpanData {
+	start := time.Now()
+	return &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID:      trace.TraceID{1},
+			SpanID:       trace.SpanID{id},
+			TraceOptions: 1,
+		},
+		Name:      name,
+		StartTime: start,
+		EndTime:   start.Add(time.Millisecond),
+	}
+}
+
+func TestClientToReceiver(t *testing.T) {
+	rec := &recordingExporter{}
+	srv := httptest.NewServer(&Receiver{Aggregator: &aggregator.Exporter{Downstream: rec}})
+	defer srv.Close()
+
+	c, err := NewClient(Options{URL: srv.URL, BatchSize: 2, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	c.ExportSpan(testSpan(1, "a"))
+	c.ExportSpan(testSpan(2, "b"))
+	deadline := time.Now().Add(time.Second)
+	for rec.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := rec.count(); got != 2 {
+		t.Fatalf("after a full batch: got %d spans; want 2", got)
+	}
+
+	c.ExportSpan(testSpan(3, "c"))
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if got := rec.count(); got != 3 {
+		t.Errorf("after Flush: got %d spans; want 3", got)
+	}
+}
+
+func TestClientStopFlushes(t *testing.T) {
+	rec := &recordingExporter{}
+	srv := httptest.NewServer(&Receiver{Aggregator: &aggregator.Exporter{Downstream: rec}})
+	defer srv.Close()
+
+	c, err := NewClient(Options{URL: srv.URL, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := trace.JSONCodec().EncodeServerless(testSpan(1, "a"))
+	c.Fallback(nil, []string{v})
+	c.Stop()
+	if got := rec.count(); got != 1 {
+		t.Errorf("after Stop: got %d spans; want 1", got)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	if _, err := NewClient(Options{}); err == nil {
+		t.Errorf("NewClient() without URL succeeded")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Options{URL: srv.URL, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.ExportSpan(testSpan(1, "a"))
+	if err := c.Flush(); err == nil {
+		t.Errorf("Flush() to a failing collector succeeded")
+	}
+}
+
+func TestClientQueueLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Options{URL: srv.URL, BatchSize: 100, FlushInterval: time.Hour, MaxQueueSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	c.Send("a", "b")
+	c.Send("c", "d", "e")
+	if got := c.DroppedSpans(); got != 2 {
+		t.Errorf("DroppedSpans() with a full queue = %d; want 2", got)
+	}
+	if err := c.Flush(); err == nil {
+		t.Fatalf("Flush() to a failing collector succeeded")
+	}
+	if got := c.DroppedSpans(); got != 5 {
+		t.Errorf("DroppedSpans() after a failed batch = %d; want 5", got)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	c, err := NewClient(Options{URL: srv.URL, FlushInterval: time.Hour, Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Send("a")
+	flushed := make(chan error, 1)
+	go func() { flushed <- c.Flush() }()
+	select {
+	case err := <-flushed:
+		if err == nil {
+			t.Errorf("Flush() to a hanging collector succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Flush() to a hanging collector did not time out")
+	}
+	c.Stop()
+}
+
+func TestReceiverRejects(t *testing.T) {
+	rc := &Receiver{Aggregator: &aggregator.Exporter{Downstream: &recordingExporter{}}}
+	tests := []struct {
+		method, body string
+		want         int
+	}{
+		{"GET", "", http.StatusMethodNotAllowed},
+		{"POST", "not json", http.StatusBadRequest},
+		{"POST", "[]", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		rc.ServeHTTP(w, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s %q: status = %d; want %d", tt.method, tt.body, w.Code, tt.want)
+		}
+	}
+}
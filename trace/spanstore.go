@@ -46,11 +46,14 @@ func (i internalOnly) ReportActiveSpans(name string) []*SpanData {
 		return nil
 	}
 	var out []*SpanData
+	r := config.Load().(*Config).Redactor
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	for activeSpan := range s.active {
 		if s, ok := activeSpan.(*span); ok {
-			out = append(out, s.makeSpanData())
+			sd := s.makeSpanData()
+			r.Redact(sd)
+			out = append(out, sd)
 		}
 	}
 	return out
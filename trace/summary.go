@@ -0,0 +1,304 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Attributes of the summary records that stand in for sibling spans
+// collapsed with Config.SummarizeSiblings, in addition to
+// MergedSpanCountAttribute holding the number of spans summarized.
+// Durations are in microseconds.
+const (
+	SummaryMinAttribute        = "agg.summary.min_us"
+	SummaryMaxAttribute        = "agg.summary.max_us"
+	SummaryMeanAttribute       = "agg.summary.mean_us"
+	SummaryP50Attribute        = "agg.summary.p50_us"
+	SummaryP99Attribute        = "agg.summary.p99_us"
+	SummaryErrorCountAttribute = "agg.summary.error_count"
+
+	// SummaryExemplarsAttribute holds the comma separated IDs of up to
+	// three of the summarized spans, failed and slow ones first.
+	SummaryExemplarsAttribute = "agg.summary.exemplars"
+)
+
+const maxSummaryExemplars = 3
+
+// IsSummary reports whether sd is a summary record of sibling spans.
+func IsSummary(sd *SpanData) bool {
+	_, ok := sd.Attributes[SummaryMinAttribute]
+	return ok
+}
+
+// siblingKey identifies spans that are interchangeable for summaries and
+// budget merges.
+type siblingKey struct {
+	traceID TraceID
+	parent  SpanID
+	name    string
+}
+
+// summarizeSiblings collapses groups of at least threshold compact sibling
+// spans sharing a name into compact summary records, unless the summary
+// would take more bytes than the group. The descendants of collapsed
+// spans are moved under the summary, where they may in turn be collapsed,
+// so fan-out at any depth yields a bounded number of entries. Complete
+// spans are kept as they are.
+func summarizeSiblings(values []string, codec PiggybackCodec, threshold int) []string {
+	if threshold < 2 || len(values) < threshold {
+		return values
+	}
+	var (
+		passed  []string // dropped entries and values that cannot be decoded
+		entries []*aggregationEntry
+	)
+	for _, v := range values {
+		if _, ok := ParseDroppedEntry(v); ok {
+			passed = append(passed, v)
+			continue
+		}
+		sd, err := codec.Decode(v)
+		if err != nil {
+			passed = append(passed, v)
+			continue
+		}
+		entries = append(entries, &aggregationEntry{value: v, sd: sd})
+	}
+
+	for {
+		var keys []siblingKey
+		groups := make(map[siblingKey][]*aggregationEntry)
+		for _, e := range entries {
+			if e.complete() {
+				continue
+			}
+			k := siblingKey{e.sd.TraceID, e.sd.ParentSpanID, e.sd.Name}
+			if _, ok := groups[k]; !ok {
+				keys = append(keys, k)
+			}
+			groups[k] = append(groups[k], e)
+		}
+
+		reparent := make(map[SpanID]SpanID)
+		summaries := make(map[*aggregationEntry]*aggregationEntry)
+		for _, k := range keys {
+			group := groups[k]
+			count := 0
+			for _, e := range group {
+				count += entryCount(e)
+			}
+			if len(group) < 2 || count < threshold {
+				continue
+			}
+			s, err := summarizeGroup(group, codec)
+			if err != nil || !smallerThan(s.value, group) {
+				continue
+			}
+			for _, e := range group {
+				summaries[e] = s
+				reparent[e.sd.SpanID] = s.sd.SpanID
+			}
+		}
+		if len(summaries) == 0 {
+			break
+		}
+
+		out := entries[:0]
+		emitted := make(map[*aggregationEntry]bool)
+		for _, e := range entries {
+			if s, ok := summaries[e]; ok {
+				if !emitted[s] {
+					emitted[s] = true
+					out = append(out, s)
+				}
+				continue
+			}
+			out = append(out, e)
+		}
+		for _, e := range out {
+			// Summaries may have been made of the children of a group
+			// collapsed in the same pass.
+			if p, ok := reparent[e.sd.ParentSpanID]; ok && p != e.sd.ParentSpanID {
+				e.sd.ParentSpanID = p
+				if v, err := reencodeEntry(e.sd, codec); err == nil {
+					e.value = v
+				}
+			}
+		}
+		entries = out
+	}
+
+	out := make([]string, 0, len(entries)+len(passed))
+	for _, e := range entries {
+		out = append(out, e.value)
+	}
+	return append(out, passed...)
+}
+
+// summaryMember is a duration observed for count of the summarized spans.
+type summaryMember struct {
+	d     time.Duration
+	count int
+}
+
+// summarizeGroup returns the summary record of a group of sibling entries,
+// which may include earlier summaries. The quantiles of earlier summaries
+// are approximated by their p50 and p99.
+func summarizeGroup(group []*aggregationEntry, codec PiggybackCodec) (*aggregationEntry, error) {
+	first := group[0].sd
+	sd := &SpanData{
+		SpanContext:     SpanContext{TraceID: first.TraceID, SpanID: first.SpanID},
+		ParentSpanID:    first.ParentSpanID,
+		SpanKind:        first.SpanKind,
+		Name:            first.Name,
+		StartTime:       first.StartTime,
+		EndTime:         first.EndTime,
+		HasRemoteParent: first.HasRemoteParent,
+	}
+
+	type exemplar struct {
+		id     string
+		failed bool
+		d      time.Duration
+	}
+	var (
+		count, errors int
+		lo, hi, sum   time.Duration
+		members       []summaryMember
+		exemplars     []exemplar
+		inherited     []string
+	)
+	for i, e := range group {
+		if e.sd.StartTime.Before(sd.StartTime) {
+			sd.StartTime = e.sd.StartTime
+		}
+		if e.sd.EndTime.After(sd.EndTime) {
+			sd.EndTime = e.sd.EndTime
+		}
+		n := entryCount(e)
+		var min, max time.Duration
+		if IsSummary(e.sd) {
+			min = summaryDuration(e.sd, SummaryMinAttribute)
+			max = summaryDuration(e.sd, SummaryMaxAttribute)
+			sum += summaryDuration(e.sd, SummaryMeanAttribute) * time.Duration(n)
+			errors += int(intAttribute(e.sd, SummaryErrorCountAttribute))
+			k := (n + 99) / 100
+			members = append(members,
+				summaryMember{summaryDuration(e.sd, SummaryP50Attribute), n - k},
+				summaryMember{summaryDuration(e.sd, SummaryP99Attribute), k})
+			if s, ok := e.sd.Attributes[SummaryExemplarsAttribute].(string); ok && s != "" {
+				inherited = append(inherited, strings.Split(s, ",")...)
+			}
+		} else {
+			d := e.sd.EndTime.Sub(e.sd.StartTime)
+			min, max = d, d
+			sum += d * time.Duration(n)
+			failed := e.sd.Status.Code != StatusCodeOK
+			if failed {
+				errors += n
+			}
+			members = append(members, summaryMember{d, n})
+			exemplars = append(exemplars, exemplar{e.sd.SpanID.String(), failed, d})
+		}
+		if i == 0 || min < lo {
+			lo = min
+		}
+		if i == 0 || max > hi {
+			hi = max
+		}
+		count += n
+	}
+
+	sort.SliceStable(exemplars, func(i, j int) bool {
+		if exemplars[i].failed != exemplars[j].failed {
+			return exemplars[i].failed
+		}
+		return exemplars[i].d > exemplars[j].d
+	})
+	var ids []string
+	for _, x := range exemplars {
+		ids = append(ids, x.id)
+	}
+	ids = append(ids, inherited...)
+	if len(ids) > maxSummaryExemplars {
+		ids = ids[:maxSummaryExemplars]
+	}
+
+	sd.Attributes = map[string]interface{}{
+		MergedSpanCountAttribute:   int64(count),
+		SummaryMinAttribute:        lo.Microseconds(),
+		SummaryMaxAttribute:        hi.Microseconds(),
+		SummaryMeanAttribute:       (sum / time.Duration(count)).Microseconds(),
+		SummaryP50Attribute:        memberQuantile(members, 0.5).Microseconds(),
+		SummaryP99Attribute:        memberQuantile(members, 0.99).Microseconds(),
+		SummaryErrorCountAttribute: int64(errors),
+		SummaryExemplarsAttribute:  strings.Join(ids, ","),
+	}
+	v, err := encodeCompactEntry(sd, codec)
+	if err != nil {
+		return nil, err
+	}
+	return &aggregationEntry{value: v, sd: sd}, nil
+}
+
+// memberQuantile returns the q-quantile of the durations of members,
+// weighted by their counts.
+func memberQuantile(members []summaryMember, q float64) time.Duration {
+	sort.Slice(members, func(i, j int) bool { return members[i].d < members[j].d })
+	total := 0
+	for _, m := range members {
+		total += m.count
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int(q * float64(total-1))
+	seen := 0
+	for _, m := range members {
+		seen += m.count
+		if rank < seen {
+			return m.d
+		}
+	}
+	return members[len(members)-1].d
+}
+
+// reencodeEntry encodes sd again after its parent changed, in the same form
+// it was decoded from.
+func reencodeEntry(sd *SpanData, codec PiggybackCodec) (string, error) {
+	if sd.TraceOptions.IsSampled() {
+		return codec.EncodeSpanData(sd)
+	}
+	return encodeCompactEntry(sd, codec)
+}
+
+func summaryDuration(sd *SpanData, key string) time.Duration {
+	return time.Duration(intAttribute(sd, key)) * time.Microsecond
+}
+
+// intAttribute returns the integer attribute key of sd, which JSONCodec
+// decodes as a float64 in complete spans.
+func intAttribute(sd *SpanData, key string) int64 {
+	switch n := sd.Attributes[key].(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
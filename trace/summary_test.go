@@ -0,0 +1,262 @@
+// Copyright 2021, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fanOutSpan(id, parent byte, name string, d time.Duration) *SpanData {
+	start := time.UnixMicro(1600000000000000)
+	return &SpanData{
+		SpanContext:  SpanContext{TraceID: TraceID{1}, SpanID: SpanID{id}},
+		ParentSpanID: SpanID{parent},
+		Name:         name,
+		StartTime:    start,
+		EndTime:      start.Add(d),
+	}
+}
+
+func TestSummarizeSiblings(t *testing.T) {
+	schema := CompactSchema{Status: true}
+	for name, codec := range testCodecs {
+		encode := func(sd *SpanData) string {
+			v, err := codec.EncodeCompact(sd, schema)
+			if err != nil {
+				t.Fatalf("%s: EncodeCompact() error: %v", name, err)
+			}
+			return v
+		}
+		var values []string
+		for i := byte(1); i <= 5; i++ {
+			call := fanOutSpan(10+i, 1, "call", time.Duration(i)*time.Millisecond)
+			if i == 2 {
+				call.Status = Status{Code: StatusCodeUnavailable}
+			}
+			srv := fanOutSpan(20+i, 10+i, "srv", time.Duration(i)*time.Millisecond/2)
+			srv.HasRemoteParent = true
+			values = append(values, encode(srv), encode(call))
+		}
+		values = append(values,
+			encode(fanOutSpan(30, 1, "other", time.Millisecond)),
+			encode(fanOutSpan(31, 1, "other", time.Millisecond)),
+			makeDroppedEntry(2))
+		slow := fanOutSpan(32, 1, "call", time.Second)
+		slow.TraceOptions = 1
+		full, err := codec.EncodeSpanData(slow)
+		if err != nil {
+			t.Fatalf("%s: EncodeSpanData() error: %v", name, err)
+		}
+		values = append(values, full)
+
+		got := summarizeSiblings(values, codec, 3)
+		byName := make(map[string][]*SpanData)
+		var dropped int
+		for _, v := range got {
+			if n, ok := ParseDroppedEntry(v); ok {
+				dropped += n
+				continue
+			}
+			sd, err := codec.Decode(v)
+			if err != nil {
+				t.Fatalf("%s: Decode(%q) error: %v", name, v, err)
+			}
+			byName[sd.Name] = append(byName[sd.Name], sd)
+		}
+		if dropped != 2 {
+			t.Errorf("%s: dropped = %d; want 2", name, dropped)
+		}
+		if n := len(byName["other"]); n != 2 {
+			t.Errorf("%s: %d spans below the threshold; want 2 kept", name, n)
+		}
+		var call *SpanData
+		for _, sd := range byName["call"] {
+			if sd.TraceOptions.IsSampled() {
+				continue // the complete span is kept
+			}
+			call = sd
+		}
+		if len(byName["call"]) != 2 || call == nil || !IsSummary(call) {
+			t.Fatalf("%s: call spans = %+v; want a summary and the complete span", name, byName["call"])
+		}
+		wantAttrs := map[string]int64{
+			MergedSpanCountAttribute:   5,
+			SummaryMinAttribute:        1000,
+			SummaryMaxAttribute:        5000,
+			SummaryMeanAttribute:       3000,
+			SummaryP50Attribute:        3000,
+			SummaryP99Attribute:        4000,
+			SummaryErrorCountAttribute: 1,
+		}
+		for k, want := range wantAttrs {
+			if got := intAttribute(call, k); got != want {
+				t.Errorf("%s: call summary %s = %d; want %d", name, k, got, want)
+			}
+		}
+		exemplars := strings.Split(call.Attributes[SummaryExemplarsAttribute].(string), ",")
+		if want := []string{SpanID{12}.String(), SpanID{15}.String(), SpanID{14}.String()}; strings.Join(exemplars, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: exemplars = %v; want %v", name, exemplars, want)
+		}
+
+		// The server spans are moved under the call summary and collapsed.
+		srv := byName["srv"]
+		if len(srv) != 1 || !IsSummary(srv[0]) {
+			t.Fatalf("%s: srv spans = %+v; want a single summary", name, srv)
+		}
+		if srv[0].ParentSpanID != call.SpanID {
+			t.Errorf("%s: srv summary parent = %v; want the call summary %v", name, srv[0].ParentSpanID, call.SpanID)
+		}
+		if !srv[0].HasRemoteParent {
+			t.Errorf("%s: srv summary lost HasRemoteParent", name)
+		}
+		if got := intAttribute(srv[0], MergedSpanCountAttribute); got != 5 {
+			t.Errorf("%s: srv summary count = %d; want 5", name, got)
+		}
+	}
+}
+
+func TestSummarizeShrinks(t *testing.T) {
+	for name, codec := range testCodecs {
+		var values []string
+		for i := byte(1); i <= 3; i++ {
+			v, err := codec.EncodeServerless(fanOutSpan(i, 9, "child", time.Duration(i)*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v)
+		}
+		got := summarizeSiblings(values, codec, 3)
+		if size, before := aggregationSize(got), aggregationSize(values); size > before {
+			t.Errorf("%s: summarized size = %d; want at most the %d bytes of the siblings", name, size, before)
+		}
+		if name == "json" && len(got) != 1 {
+			t.Errorf("%s: three siblings summarized into %d entries; want 1", name, len(got))
+		}
+		if len(got) == 1 {
+			sd, err := codec.Decode(got[0])
+			if err != nil {
+				t.Fatalf("%s: Decode(%q) error: %v", name, got[0], err)
+			}
+			if !IsSummary(sd) || sd.TraceOptions.IsSampled() {
+				t.Errorf("%s: summary = %+v; want a compact summary record", name, sd)
+			}
+		}
+	}
+
+	// A pair of siblings is smaller than their summary, so it is kept.
+	codec := JSONCodec()
+	var pair []string
+	for i := byte(1); i <= 2; i++ {
+		v, err := codec.EncodeServerless(fanOutSpan(i, 9, "child", time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pair = append(pair, v)
+	}
+	if got := summarizeSiblings(pair, codec, 2); len(got) != 2 {
+		t.Errorf("pair of siblings summarized into %d entries; want them kept", len(got))
+	}
+}
+
+func TestSummarizeSummaries(t *testing.T) {
+	codec := JSONCodec()
+	var values []string
+	for p := byte(1); p <= 4; p++ {
+		var group []string
+		for i := byte(0); i < 4; i++ {
+			v, err := codec.EncodeServerless(fanOutSpan(p*10+i, p, "leaf", time.Duration(i+1)*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			group = append(group, v)
+		}
+		values = append(values, summarizeSiblings(group, codec, 2)...)
+		v, err := codec.EncodeServerless(fanOutSpan(p, 100, "mid", time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+
+	got := summarizeSiblings(values, codec, 2)
+	var names []string
+	var leaf *SpanData
+	for _, v := range got {
+		sd, err := codec.Decode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, sd.Name)
+		if sd.Name == "leaf" {
+			leaf = sd
+		}
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "leaf,mid" {
+		t.Fatalf("summarized entries = %v; want a leaf and a mid summary", names)
+	}
+	if got := intAttribute(leaf, MergedSpanCountAttribute); got != 16 {
+		t.Errorf("merged summary count = %d; want 16", got)
+	}
+	if got := intAttribute(leaf, SummaryMeanAttribute); got != 2500 {
+		t.Errorf("merged summary mean = %d; want 2500", got)
+	}
+	if got := intAttribute(leaf, SummaryMaxAttribute); got != 4000 {
+		t.Errorf("merged summary max = %d; want 4000", got)
+	}
+}
+
+func TestSummaryCountsErrorsWithDefaultSchema(t *testing.T) {
+	old := config.Load()
+	defer config.Store(old)
+	ApplyConfig(Config{SummarizeSiblings: 3, PiggybackCodec: JSONCodec()})
+	e := &piggybackExporter{}
+	RegisterExporter(e)
+	defer UnregisterExporter(e)
+
+	ctx, server := StartSpan(context.Background(), "server", WithSampler(AlwaysSample()))
+	for i := 0; i < 3; i++ {
+		_, call := StartSpan(ctx, "call")
+		if i == 1 {
+			call.SetStatus(Status{Code: StatusCodeUnavailable})
+		}
+		call.EndAtClient(&http.Header{})
+	}
+	w := httptest.NewRecorder()
+	server.EndAndAggregate(w, nil)
+
+	var summary *SpanData
+	for _, v := range w.Header()[AggregationHeader] {
+		sd, err := JSONCodec().Decode(v)
+		if err != nil {
+			t.Fatalf("Decode(%q) error: %v", v, err)
+		}
+		if IsSummary(sd) {
+			summary = sd
+		}
+	}
+	if summary == nil {
+		t.Fatalf("no summary in %v", w.Header()[AggregationHeader])
+	}
+	if got := intAttribute(summary, SummaryErrorCountAttribute); got != 1 {
+		t.Errorf("error count = %d; want 1", got)
+	}
+}
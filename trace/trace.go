@@ -15,11 +15,9 @@
 package trace
 
 import (
-	"bytes"
 	"context"
 	crand "crypto/rand"
 	"encoding/binary"
-	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -68,6 +66,16 @@ type span struct {
 	*spanStore
 	endOnce sync.Once
 
+	// parent is the local parent of this span, if any. Aggregated span
+	// entries collected by this span are handed to it once this span ends.
+	parent *span
+
+	// aggregated holds the Agg values piggybacked by finished descendants,
+	// protected by mu. aggregatedDone is set once they have been emitted or
+	// forwarded, after which new values go straight to the parent.
+	aggregated     []string
+	aggregatedDone bool
+
 	executionTracerTaskEnd func() // ends the execution tracer span
 }
 
@@ -177,9 +185,11 @@ func WithSampler(sampler Sampler) StartOption {
 func (t *tracer) StartSpan(ctx context.Context, name string, o ...StartOption) (context.Context, *Span) {
 	var opts StartOptions
 	var parent SpanContext
+	var localParent *span
 	if p := t.FromContext(ctx); p != nil {
 		if ps, ok := p.internal.(*span); ok {
 			ps.addChild()
+			localParent = ps
 		}
 		parent = p.SpanContext()
 	}
@@ -187,11 +197,14 @@ func (t *tracer) StartSpan(ctx context.Context, name string, o ...StartOption) (
 		op(&opts)
 	}
 	span := startSpanInternal(name, parent != SpanContext{}, parent, false, opts)
+	span.parent = localParent
 
 	ctx, end := startExecutionTracerTask(ctx, name)
 	span.executionTracerTaskEnd = end
 	extSpan := NewSpan(span)
-	return t.NewContext(ctx, extSpan), extSpan
+	ctx = t.NewContext(ctx, extSpan)
+	startSpanProcessors(ctx, extSpan)
+	return ctx, extSpan
 }
 
 // StartSpanWithRemoteParent starts a new child span of the span from the given parent.
@@ -210,7 +223,9 @@ func (t *tracer) StartSpanWithRemoteParent(ctx context.Context, name string, par
 	ctx, end := startExecutionTracerTask(ctx, name)
 	span.executionTracerTaskEnd = end
 	extSpan := NewSpan(span)
-	return t.NewContext(ctx, extSpan), extSpan
+	ctx = t.NewContext(ctx, extSpan)
+	startSpanProcessors(ctx, extSpan)
+	return ctx, extSpan
 }
 
 func startSpanInternal(name string, hasParent bool, parent SpanContext, remoteParent bool, o StartOptions) *span {
@@ -246,7 +261,7 @@ func startSpanInternal(name string, hasParent bool, parent SpanContext, remotePa
 			HasRemoteParent: remoteParent}).Sample)
 	}
 
-	if !internal.LocalSpanStoreEnabled && !s.spanContext.IsSampled() {
+	if shutDown() || (!internal.LocalSpanStoreEnabled && !s.spanContext.IsSampled()) {
 		return s
 	}
 
@@ -266,7 +281,8 @@ func startSpanInternal(name string, hasParent bool, parent SpanContext, remotePa
 		s.data.ParentSpanID = parent.SpanID
 	}
 	if internal.LocalSpanStoreEnabled {
-		ss := spanStoreForNameCreateIfNew(name)
+		// zpages lists span stores by name, so keep it redacted too.
+		ss := spanStoreForNameCreateIfNew(cfg.Redactor.redactName(name))
 		if ss != nil {
 			s.spanStore = ss
 			ss.add(s)
@@ -290,12 +306,8 @@ func (s *span) End() {
 	s.endOnce.Do(func() {
 		exp, _ := exporters.Load().(exportersMap)
 		mustExport := s.spanContext.IsSampled() && len(exp) > 0
-		if s.spanStore != nil || mustExport {
-			sd := s.makeSpanData()
-			sd.EndTime = internal.MonotonicEndTime(sd.StartTime)
-			if s.spanStore != nil {
-				s.spanStore.finished(s, sd)
-			}
+		if s.spanStore != nil || mustExport || len(spanProcessors()) > 0 {
+			sd := s.finish()
 			// Currently move whether to export into Exporters.
 			if mustExport {
 				for e := range exp {
@@ -303,10 +315,19 @@ func (s *span) End() {
 				}
 			}
 		}
+		// A plain span has no response to carry aggregated entries, so pass
+		// anything its descendants collected on to the enclosing span, or
+		// export it directly if there is none, as for a server span.
+		if values := s.takeAggregated(); !s.forwardAggregated(values) {
+			if err := ExportAggregated(values); err != nil {
+				ReportError(err)
+			}
+		}
 	})
 }
 
-// End ends the span with response aggregation
+// EndAndAggregate ends the span with response aggregation; see
+// Span.EndAndAggregate.
 func (s *span) EndAndAggregate(w http.ResponseWriter, r *http.Request) {
 	if s == nil {
 		return
@@ -320,52 +341,80 @@ func (s *span) EndAndAggregate(w http.ResponseWriter, r *http.Request) {
 	s.endOnce.Do(func() {
 		exp, _ := exporters.Load().(exportersMap)
 		mustExport := s.spanContext.IsSampled() && len(exp) > 0
-		if s.spanStore != nil || mustExport {
-			sd := s.makeSpanData()
-			sd.EndTime = internal.MonotonicEndTime(sd.StartTime)
-			if s.spanStore != nil {
-				s.spanStore.finished(s, sd)
-			}
+		if s.spanStore != nil || mustExport || len(spanProcessors()) > 0 {
+			sd := s.finish()
 			if mustExport {
+				cfg := config.Load().(*Config)
+				codec := cfg.PiggybackCodec
+				budget := cfg.MaxAggregationHeaderBytes
+				if r != nil {
+					caps, ok := RequestAggregationCapabilities(r)
+					if !ok || !caps.Accepts(codec) {
+						// The caller did not advertise that it can decode
+						// our entries, and would drop them, so export them
+						// directly.
+						if err := ExportAggregated(s.takeAggregated()); err != nil {
+							ReportError(err)
+						}
+						for e := range exp {
+							e.ExportSpan(sd)
+						}
+						return
+					}
+					if caps.MaxBytes > 0 && (budget <= 0 || caps.MaxBytes < budget) {
+						budget = caps.MaxBytes
+					}
+				}
+				// Emit the entries piggybacked by downstream calls first so
+				// the caller receives the whole subtree with this span.
+				for _, v := range summarizeSiblings(s.takeAggregated(), codec, cfg.SummarizeSiblings) {
+					w.Header().Add(AggregationHeader, v)
+				}
 				// Check whether the request is valid or not
 				for e := range exp {
-					errType := e.FilterSpan(sd)
+					ae, ok := e.(AggregatingExporter)
+					if !ok {
+						e.ExportSpan(sd)
+						continue
+					}
+					errType := ae.FilterSpan(sd)
 					switch errType {
 					case OK:
-						ssd := makeServerlessSpanData(sd)
 						// Valid one, encoding information into the response header
-						buf := new(bytes.Buffer)
-						err := json.NewEncoder(buf).Encode(ssd)
+						v, err := piggyback(codec, sd, ae, false)
 						if err != nil {
-							fmt.Println("Failed to encoding data into hdr", err)
-							return
+							handleEncodeError(ae, sd, err)
+							continue
 						}
-						w.Header().Add("Agg", buf.String())
+						w.Header().Add(AggregationHeader, v)
 					case Aggregate:
-						ssd := makeServerlessSpanData(sd)
 						// Valid one, encoding information into the response header
-						buf := new(bytes.Buffer)
-						err := json.NewEncoder(buf).Encode(ssd)
-						if err != nil {
-							fmt.Println("Failed to encoding data into hdr", err)
-							return
+						if v, err := piggyback(codec, sd, ae, false); err != nil {
+							handleEncodeError(ae, sd, err)
+						} else {
+							w.Header().Add(AggregationHeader, v)
 						}
-						w.Header().Add("Agg", buf.String())
-						e.AggregateSpanFromHeader(w.Header())
+						ae.AggregateSpanFromHeader(w.Header())
 					case PerformanceDown:
 						// Just encoding the whole information here
-						buf := new(bytes.Buffer)
-						err := json.NewEncoder(buf).Encode(sd)
+						v, err := piggyback(codec, sd, ae, true)
 						if err != nil {
-							fmt.Println("Failed to encoding data into hdr", err)
-							return
+							handleEncodeError(ae, sd, err)
+							continue
 						}
-						w.Header().Add("Agg", buf.String())
+						w.Header().Add(AggregationHeader, v)
 					case Error, UserSpec:
 						// Report the span immediately
-						e.ExportSpan(sd)
+						ae.ExportSpan(sd)
+						recordImmediateExport(sd.Name, errType)
 					}
 				}
+				// Keep the response within the configured header budget.
+				if values := w.Header()[AggregationHeader]; len(values) > 0 {
+					fitted := fitAggregationBudget(values, codec, budget)
+					recordHeaderStats(sd.Name, values, fitted)
+					w.Header()[AggregationHeader] = fitted
+				}
 			}
 		}
 	})
@@ -384,57 +433,88 @@ func (s *span) EndAtClient(resp *http.Header) {
 	s.endOnce.Do(func() {
 		exp, _ := exporters.Load().(exportersMap)
 		mustExport := s.spanContext.IsSampled() && len(exp) > 0
-		if s.spanStore != nil || mustExport {
-			sd := s.makeSpanData()
-			sd.EndTime = internal.MonotonicEndTime(sd.StartTime)
-			if s.spanStore != nil {
-				s.spanStore.finished(s, sd)
-			}
+		if s.spanStore != nil || mustExport || len(spanProcessors()) > 0 {
+			sd := s.finish()
 			if mustExport {
+				// resp already holds the entries the server piggybacked on
+				// its trailer; merge in anything collected locally.
+				for _, v := range s.takeAggregated() {
+					resp.Add(AggregationHeader, v)
+				}
+				aggregated := false
+				cfg := config.Load().(*Config)
+				codec := cfg.PiggybackCodec
+				if values := (*resp)[AggregationHeader]; len(values) > 0 {
+					(*resp)[AggregationHeader] = summarizeSiblings(values, codec, cfg.SummarizeSiblings)
+				}
 				// Check whether the request is valid or not
 				for e := range exp {
-					errType := e.FilterSpan(sd)
+					ae, ok := e.(AggregatingExporter)
+					if !ok {
+						e.ExportSpan(sd)
+						continue
+					}
+					errType := ae.FilterSpan(sd)
 					switch errType {
 					case OK:
-						ssd := makeServerlessSpanData(sd)
 						// Valid one, encoding information into the response header
-						buf := new(bytes.Buffer)
-						err := json.NewEncoder(buf).Encode(ssd)
+						v, err := piggyback(codec, sd, ae, false)
 						if err != nil {
-							fmt.Println("Failed to encoding data into hdr", err)
-							return
+							handleEncodeError(ae, sd, err)
+							continue
 						}
-						resp.Add("Agg", buf.String())
+						resp.Add(AggregationHeader, v)
 					case Aggregate:
-						ssd := makeServerlessSpanData(sd)
 						// Valid one, encoding information into the response header
-						buf := new(bytes.Buffer)
-						err := json.NewEncoder(buf).Encode(ssd)
-						if err != nil {
-							fmt.Println("Failed to encoding data into hdr", err)
-							return
+						if v, err := piggyback(codec, sd, ae, false); err != nil {
+							handleEncodeError(ae, sd, err)
+						} else {
+							resp.Add(AggregationHeader, v)
 						}
-						resp.Add("Agg", buf.String())
-						e.AggregateSpanFromHeader(*resp)
+						ae.AggregateSpanFromHeader(*resp)
+						aggregated = true
 					case PerformanceDown:
 						// Just encoding the whole information here
-						buf := new(bytes.Buffer)
-						err := json.NewEncoder(buf).Encode(sd)
+						v, err := piggyback(codec, sd, ae, true)
 						if err != nil {
-							fmt.Println("Failed to encoding data into hdr", err)
-							return
+							handleEncodeError(ae, sd, err)
+							continue
 						}
-						resp.Add("Agg", buf.String())
+						resp.Add(AggregationHeader, v)
 					case Error, UserSpec:
 						// Report the span immediately
-						e.ExportSpan(sd)
+						ae.ExportSpan(sd)
+						recordImmediateExport(sd.Name, errType)
 					}
 				}
+				// Hand the combined set to the enclosing span unless it was
+				// already consumed by an aggregation point here.
+				// Without one, the entries stay in resp for the caller.
+				if !aggregated {
+					s.forwardAggregated((*resp)[AggregationHeader])
+				}
 			}
 		}
 	})
 }
 
+// finish makes the SpanData of an ended span, redacts it, passes it to the
+// span processors and records it in the local span store and latency
+// baselines.
+func (s *span) finish() *SpanData {
+	sd := s.makeSpanData()
+	sd.EndTime = internal.MonotonicEndTime(sd.StartTime)
+	config.Load().(*Config).Redactor.Redact(sd)
+	for _, p := range spanProcessors() {
+		p.OnEnd(sd)
+	}
+	if s.spanStore != nil {
+		s.spanStore.finished(s, sd)
+	}
+	recordLatencyBaseline(sd)
+	return sd
+}
+
 // makeSpanData produces a SpanData representing the current state of the Span.
 // It requires that s.data is non-nil.
 func (s *span) makeSpanData() *SpanData {
@@ -461,12 +541,39 @@ func (s *span) makeSpanData() *SpanData {
 	return &sd
 }
 
+// takeAggregated returns the Agg values collected from descendants and marks
+// them as emitted, so that late arrivals are forwarded to the parent instead.
+func (s *span) takeAggregated() []string {
+	s.mu.Lock()
+	values := s.aggregated
+	s.aggregated = nil
+	s.aggregatedDone = true
+	s.mu.Unlock()
+	return values
+}
+
+// forwardAggregated hands Agg values to the closest ancestor that has not yet
+// emitted its own, and reports whether there was one.
+func (s *span) forwardAggregated(values []string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for p := s.parent; p != nil; p = p.parent {
+		p.mu.Lock()
+		if !p.aggregatedDone {
+			p.aggregated = append(p.aggregated, values...)
+			p.mu.Unlock()
+			return true
+		}
+		p.mu.Unlock()
+	}
+	return false
+}
+
 func makeServerlessSpanData(sd *SpanData) ServerlessSpanData {
 	var ssd ServerlessSpanData
 
 	ssd.TraceID = sd.TraceID.String()
-	// TODO: maybe use base64 encoding here to reduce network packet size?
-	// ssd.TraceID = base64.StdEncoding.EncodeToString(sd.TraceID[:])
 	ssd.SpanID = sd.SpanID.String()
 	// Maybe parentSpanId can be NULL
 	if sd.ParentSpanID != [8]byte{} {
@@ -477,6 +584,21 @@ func makeServerlessSpanData(sd *SpanData) ServerlessSpanData {
 	ssd.Name = sd.Name
 	ssd.StartTime = strconv.FormatInt(sd.StartTime.UnixMicro(), 10)
 	ssd.Duration = strconv.FormatInt(sd.EndTime.UnixMicro()-sd.StartTime.UnixMicro(), 10)
+	setInvocationFields(&ssd, sd)
+	ssd.Version = CompactSchemaVersion
+	ssd.StatusCode = sd.Status.Code
+	ssd.StatusMessage = sd.Status.Message
+	ssd.Kind = sd.SpanKind
+	ssd.RemoteParent = sd.HasRemoteParent
+	for k, v := range sd.Attributes {
+		if isInvocationAttribute(k) {
+			continue
+		}
+		if ssd.Attributes == nil {
+			ssd.Attributes = make(map[string]interface{})
+		}
+		ssd.Attributes[k] = v
+	}
 
 	return ssd
 }
@@ -684,6 +806,7 @@ func init() {
 		MaxAnnotationEventsPerSpan: DefaultMaxAnnotationEventsPerSpan,
 		MaxMessageEventsPerSpan:    DefaultMaxMessageEventsPerSpan,
 		MaxLinksPerSpan:            DefaultMaxLinksPerSpan,
+		PiggybackCodec:             JSONCodec(),
 	})
 }
 
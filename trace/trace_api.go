@@ -178,7 +178,13 @@ func (s *Span) End() {
 	s.internal.End()
 }
 
-// End ends the span with response aggregation
+// EndAndAggregate ends the span with response aggregation, adding the
+// span and the spans aggregated below it to the AggregationHeader of w.
+// The entries are kept within the size limit advertised in the
+// AggregationCapabilities of r. If r advertises none, or does not accept
+// the configured codec, the caller would drop the entries, so they are
+// exported directly instead. r may be nil for responses that are not HTTP,
+// which always carry the entries.
 func (s *Span) EndAndAggregate(w http.ResponseWriter, r *http.Request) {
 	if s == nil {
 		return